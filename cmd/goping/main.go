@@ -5,23 +5,57 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/dns"
+	"github.com/gracig/goping/pingers/http"
 	"github.com/gracig/goping/pingers/icmpv4"
+	"github.com/gracig/goping/pingers/icmpv6"
+	"github.com/gracig/goping/pingers/tcp"
+	"github.com/gracig/goping/pingers/udp"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 const ()
 
+//qtypes are the DNS query types accepted by the qtype flag
+var qtypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"NS":    dnsmessage.TypeNS,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+	"SOA":   dnsmessage.TypeSOA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"PTR":   dnsmessage.TypePTR,
+}
+
 var (
 	help      bool
+	ipv6      bool
+	unpriv    bool
+	tcpPort   int
+	udpPort   int
+	httpPing  bool
+	dnsQuery  string
+	dnsType   string
+	pattern   string
+	batch     int
+	shards    int
+	netNS     string
+	size      func(goping.Response) int
+	pmtu      bool
+	pmtuMax   int
 	hosts     []string
 	smoothDur time.Duration = time.Duration(1 * time.Millisecond)
 	cfg                     = goping.Config{
 		Count:      -1,
 		Interval:   time.Duration(1 * time.Second),
-		PacketSize: 100,
+		PacketSize: 56,
 		TOS:        0,
 		TTL:        64,
 		Timeout:    time.Duration(3 * time.Second),
@@ -39,10 +73,25 @@ func parseFlags() {
 	flag.DurationVar(&cfg.Interval, "i", time.Duration(1*time.Second), "The minimum interval time between pings from the same host")
 	flag.DurationVar(&cfg.Timeout, "timeout", time.Duration(4*time.Second), "The maximum time to wait for a ping response")
 	flag.DurationVar(&cfg.Timeout, "t", time.Duration(4*time.Second), "The maximum time to wait for a ping response")
-	flag.IntVar(&cfg.PacketSize, "packetsize", 64, "The size of the ICMP Packet in every request")
-	flag.IntVar(&cfg.PacketSize, "ps", 64, "The size of the ICMP Packet in every request")
+	flag.IntVar(&cfg.PacketSize, "packetsize", 56, "The size of the ICMP echo data in every request, without the 8 bytes of the ICMP header")
+	flag.IntVar(&cfg.PacketSize, "ps", 56, "The size of the ICMP echo data in every request, without the 8 bytes of the ICMP header")
+	flag.StringVar(&pattern, "pattern", "zeros", "The fill of the ICMP echo data: zeros, incrementing, random, or up to 16 bytes in hex digits repeated, as ping -p")
+	flag.IntVar(&batch, "batch", 1, "The number of packets sent and received by each syscall of the raw IPv4 pinger, with sendmmsg and recvmmsg")
+	flag.IntVar(&shards, "shards", 1, "The number of raw sockets of the raw IPv4 pinger, each one sending to and receiving from its share of the hosts")
 	flag.IntVar(&cfg.TOS, "TOS", 0, "The TOS (Type of Service) field in the ip header")
 	flag.IntVar(&cfg.TTL, "TTL", 64, "The TTL (Time to Live) field in the ip header")
+	flag.Func("I", "The source address of the pings, or the name of the interface they are sent through, as ping -I", sourceFlag(&cfg))
+	flag.IntVar(&cfg.Mark, "mark", 0, "The mark of the packets, matched by the policy routing rules and the firewall. Needs CAP_NET_ADMIN")
+	flag.StringVar(&netNS, "netns", "", "The network namespace the pings are sent from, named by ip netns or given by its path. Needs CAP_SYS_ADMIN")
+	flag.BoolVar(&ipv6, "6", false, "Ping IPv6 hosts with ICMPv6. TTL and TOS are used as hop limit and traffic class")
+	flag.IntVar(&tcpPort, "tcp", 0, "Ping with TCP handshakes to this port. Hosts may also have the form host:port")
+	flag.IntVar(&udpPort, "udp", 0, "Ping with UDP datagrams to this port. A reply or an ICMP port unreachable proves the host is alive")
+	flag.BoolVar(&httpPing, "http", false, "Ping with HTTP GET requests. Hosts are URLs")
+	flag.StringVar(&dnsQuery, "dns", "", "Ping with DNS queries for this name. Hosts are DNS servers, and may have the form host:port")
+	flag.StringVar(&dnsType, "qtype", "A", "The type of the DNS queries: A, AAAA, NS, MX, TXT, SOA, CNAME or PTR")
+	flag.BoolVar(&pmtu, "pmtu", false, "Discovers the path MTU to each host with pings that can not be fragmented, instead of pinging them")
+	flag.IntVar(&pmtuMax, "pmtumax", 1500, "The largest packet size tried by -pmtu")
+	flag.BoolVar(&unpriv, "unprivileged", false, "Ping IPv4 hosts with ping sockets. Does not need root, but the group must be in net.ipv4.ping_group_range")
 	flag.Parse()
 	hosts = flag.Args()
 	if help || len(hosts) == 0 {
@@ -53,11 +102,55 @@ func parseFlags() {
 
 func main() {
 
+	//Subcommands have their own flags
+	if len(os.Args) > 1 && os.Args[1] == "traceroute" {
+		traceroute(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mtr" {
+		mtr(os.Args[2:])
+		return
+	}
 	parseFlags()
 
-	gp := goping.New(cfg, icmpv4.New(), nil, nil)
+	fill, err := fillPattern(pattern)
+	if err != nil {
+		log.Fatalf("Invalid pattern %v: %v", pattern, err)
+	}
+	var pinger = icmpv4.New(icmpv4.Fill(fill), icmpv4.Batch(batch), icmpv4.Shards(shards), icmpv4.Namespace(netNS))
+	//The size printed for each reply is the ICMP message, as ping does. Pingers that do not send ICMP print 0
+	size = func(r goping.Response) int { return 8 + icmpv4.DataSize(r.Request.Config) }
+	noSize := func(goping.Response) int { return 0 }
+	if ipv6 {
+		pinger = icmpv6.New()
+		size = func(r goping.Response) int { return len(r.ICMPMessage) }
+	} else if unpriv {
+		pinger = icmpv4.NewUnprivileged(icmpv4.Fill(fill), icmpv4.Namespace(netNS))
+	} else if tcpPort > 0 {
+		pinger, size = tcp.New(tcpPort), noSize
+	} else if udpPort > 0 {
+		pinger, size = udp.New(udpPort), noSize
+	} else if httpPing {
+		pinger, size = http.New(), noSize
+	} else if dnsQuery != "" {
+		qtype, ok := qtypes[strings.ToUpper(dnsType)]
+		if !ok {
+			log.Fatalf("Unknown DNS query type: %v", dnsType)
+		}
+		pinger, size = dns.New(dns.Query(dnsQuery, qtype)), noSize
+	}
+	gp := goping.New(cfg, pinger, nil, nil)
 
-	ping, pong, err := gp.Start(smoothDur)
+	//Stops the session when the program is interrupted
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	if pmtu {
+		discoverPMTU(ctx, gp)
+		return
+	}
+
+	ping, pong, err := gp.StartContext(ctx, smoothDur)
 	if err != nil {
 		log.Fatalf("Could not initialize pinger: %v", err)
 	}
@@ -71,12 +164,18 @@ func main() {
 
 	//A map that count number of responses by each error found. nil = OK
 	var counter = make(map[string]uint64)
+	//Keeps the statistics of each host
+	var stats = goping.NewStatsAggregator(nil)
 
 	//Read all responses from the pong channel
-	for r := range pong {
+	for r := range stats.Consume(pong) {
 		printResponse(r)
 		counter["TOTAL"]++
-		if r.Err != nil {
+		if r.Duplicate {
+			counter["DUP"]++
+		} else if r.Late {
+			counter["LATE"]++
+		} else if r.Err != nil {
 			counter[r.Err.Error()]++
 		} else {
 			counter["OK"]++
@@ -84,6 +183,11 @@ func main() {
 
 	}
 
+	//Printing the statistics of each host
+	for _, st := range stats.Snapshots() {
+		printStats(st)
+	}
+
 	//Logging counter values
 	var buf bytes.Buffer
 	for k, v := range counter {
@@ -91,12 +195,46 @@ func main() {
 	}
 	log.Print(buf.String())
 }
+
+//sourceFlag returns the function that sets the source address of cfg, or its interface when the flag is not an address
+func sourceFlag(cfg *goping.Config) func(string) error {
+	return func(v string) error {
+		if ip := net.ParseIP(v); ip != nil {
+			cfg.Source = ip
+		} else {
+			cfg.Interface = v
+		}
+		return nil
+	}
+}
+
+//fillPattern returns the icmpv4 pattern named by the pattern flag
+func fillPattern(name string) (icmpv4.Pattern, error) {
+	switch name {
+	case "zeros":
+		return icmpv4.Zeros(), nil
+	case "incrementing":
+		return icmpv4.Incrementing(), nil
+	case "random":
+		return icmpv4.Random(), nil
+	default:
+		return icmpv4.Hex(name)
+	}
+}
+
 func printResponse(r goping.Response) {
 
 	var msg = "%-7v %3d bytes from %-15v %-20v icmp_seq=%-5d ttl=%-2d tos=%-2d time=%-8.2f %-20v\n"
+	//Duplicate and late replies are marked as ping does
+	success, failure := "SUCCESS", "FAILURE"
+	if r.Duplicate {
+		success, failure = "DUP!", "DUP!"
+	} else if r.Late {
+		success, failure = "LATE", "LATE"
+	}
 	if r.Err != nil {
 		fmt.Printf(msg,
-			"FAILURE",
+			failure,
 			0, //0 bytes for packet size
 			r.Peer,
 			r.Request.Host,
@@ -108,16 +246,36 @@ func printResponse(r goping.Response) {
 		)
 	} else {
 		fmt.Printf(msg,
-			"SUCCESS",
-			r.Request.Config.PacketSize, //0 bytes for packet size
+			success,
+			size(r),
 			r.Peer,
 			r.Request.Host,
 			r.Seq,
 			r.Request.Config.TTL,
 			r.Request.Config.TOS,
 			r.RTT,
-			"", //No error will be shown
+			replyKind(r.Kind), //No error will be shown
 		)
 	}
 
 }
+
+func printStats(st goping.Stats) {
+	fmt.Printf("--- %v ping statistics ---\n", st.Host)
+	fmt.Printf("%d packets transmitted, %d received, %.1f%% packet loss\n", st.Sent, st.Received, st.Loss)
+	if st.Duplicates > 0 || st.Late > 0 {
+		fmt.Printf("%d duplicates, %d late, %d reordered\n", st.Duplicates, st.Late, st.Reordered)
+	}
+	if st.Received > 0 {
+		fmt.Printf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms, jitter = %.3f ms\n", st.Min, st.Avg, st.Max, st.StdDev, st.Jitter)
+		fmt.Printf("rtt p50/p90/p99/p99.9 = %.3f/%.3f/%.3f/%.3f ms\n", st.P50, st.P90, st.P99, st.P999)
+	}
+}
+
+//replyKind returns the kind of reply to be printed. Echo replies are not printed
+func replyKind(k goping.ReplyKind) string {
+	if k == goping.ReplyEcho {
+		return ""
+	}
+	return k.String()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+//maxChanges is the number of path changes shown below the mtr table
+const maxChanges = 10
+
+//mtr runs the mtr subcommand with its own flags: goping mtr [flags] host...
+//It probes the hops of the path to each host and redraws a table with their statistics until it is interrupted
+func mtr(args []string) {
+	var f pathFlags
+	var refresh time.Duration
+	fs := flag.NewFlagSet("mtr", flag.ExitOnError)
+	f.register(fs, 0, time.Duration(1*time.Second))
+	fs.DurationVar(&refresh, "r", time.Duration(1*time.Second), "The interval time between redraws of the table")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintf(fs.Output(), "Usage: %v mtr [flags] host...\nProbes each hop until interrupted when -q is 0\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	hosts := fs.Args()
+
+	//Stops the monitoring when the program is interrupted. The last table is kept on the screen
+	ctx, cancel := interruptible()
+	defer cancel()
+	events, err := goping.Monitor(ctx, f.goPinger(), hosts, f.trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not start monitoring: %v\n", err)
+		os.Exit(1)
+	}
+
+	//The hops of each host indexed by TTL - FirstTTL, and the last path changes
+	paths := make(map[string][]goping.Hop)
+	var changes []goping.MonitorEvent
+	tick := time.NewTicker(refresh)
+	defer tick.Stop()
+	for {
+		select {
+		case e, open := <-events:
+			if !open {
+				drawTable(hosts, paths, changes)
+				return
+			}
+			hops := paths[e.Host]
+			for len(hops) < e.Len {
+				hops = append(hops, goping.Hop{TTL: f.trace.FirstTTL + len(hops)})
+			}
+			paths[e.Host] = hops[:e.Len]
+			if i := e.Hop.TTL - f.trace.FirstTTL; i < e.Len {
+				hops[i] = e.Hop
+			}
+			if e.Kind == goping.PathChanged {
+				if changes = append(changes, e); len(changes) > maxChanges {
+					changes = changes[1:]
+				}
+			}
+		case <-tick.C:
+			drawTable(hosts, paths, changes)
+		}
+	}
+}
+
+//drawTable clears the terminal and prints the statistics of the hops of each host and the last path changes
+func drawTable(hosts []string, paths map[string][]goping.Hop, changes []goping.MonitorEvent) {
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("goping mtr %v\n", time.Now().Format(time.RFC1123))
+	for _, host := range hosts {
+		fmt.Printf("\nHost: %v\n", host)
+		fmt.Printf("%4v  %-32v %6v %5v %7v %7v %7v %7v %7v\n", "TTL", "Address", "Loss%", "Snt", "Last", "Avg", "Best", "Wrst", "StDev")
+		for _, h := range paths[host] {
+			address := peers(h)
+			if address == "" {
+				address = "???"
+			}
+			fmt.Printf("%4d  %-32v %5.1f%% %5d %7.2f %7.2f %7.2f %7.2f %7.2f\n",
+				h.TTL, address, h.Stats.Loss, h.Stats.Sent, h.Last, h.Stats.Avg, h.Stats.Min, h.Stats.Max, h.Stats.StdDev)
+		}
+	}
+	if len(changes) > 0 {
+		fmt.Printf("\nPath changes:\n")
+		for _, e := range changes {
+			fmt.Printf("%v  %v hop %d: %v -> %v\n", e.Time.Format("15:04:05"), e.Host, e.Hop.TTL, e.Previous, e.Peer)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gracig/goping"
+)
+
+//discoverPMTU prints the path MTU to each host
+func discoverPMTU(ctx context.Context, gp goping.GoPinger) {
+	if ipv6 || tcpPort > 0 || udpPort > 0 || httpPing || dnsQuery != "" {
+		log.Fatalf("The path MTU is discovered only with ICMPv4 pings")
+	}
+	//Each size is tried with -c probes before it is considered too big
+	probes := cfg.Count
+	if probes < 1 {
+		probes = 2
+	}
+	fmt.Printf("path MTU discovery of %v hosts, up to %v bytes, %v probes per size\n", len(hosts), pmtuMax, probes)
+	results, err := goping.DiscoverPMTU(ctx, gp, hosts, goping.PMTUConfig{Max: pmtuMax, Probes: probes, SmoothInterval: smoothDur})
+	for _, r := range results {
+		if r.MTU == 0 {
+			fmt.Printf("%-20v  unknown  %v\n", r.Host, r.Err)
+			continue
+		}
+		var notes []string
+		if r.Router != nil {
+			notes = append(notes, fmt.Sprintf("Fragmentation Needed from %v", r.Router))
+		}
+		if r.BlackHole {
+			notes = append(notes, "larger packets are lost without Fragmentation Needed")
+		}
+		fmt.Printf("%-20v  %5d  %v\n", r.Host, r.MTU, strings.Join(notes, ", "))
+	}
+	if err != nil {
+		log.Fatalf("Discovery stopped: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/icmpv4"
+	"github.com/gracig/goping/pingers/icmpv6"
+)
+
+//pathFlags are the flags shared by the subcommands that probe the hops of a path
+type pathFlags struct {
+	trace        goping.TraceConfig
+	cfg          goping.Config
+	v6           bool
+	unprivileged bool
+	netns        string
+}
+
+//register adds the flags to fs. probes and interval are the defaults of the subcommand
+func (f *pathFlags) register(fs *flag.FlagSet, probes int, interval time.Duration) {
+	fs.IntVar(&f.trace.FirstTTL, "f", 1, "The TTL of the first hop probed")
+	fs.IntVar(&f.trace.MaxTTL, "m", 30, "The TTL of the last hop probed")
+	fs.IntVar(&f.trace.Probes, "q", probes, "The number of probes sent to each hop")
+	fs.IntVar(&f.trace.FlowID, "flow", 0, "Keeps every probe in the same path through load balancers, as Paris traceroute. 0 lets each probe take its own path")
+	fs.DurationVar(&f.trace.SmoothInterval, "s", time.Duration(1*time.Millisecond), "The minimum interval time between all probes")
+	fs.DurationVar(&f.cfg.Interval, "i", interval, "The minimum interval time between probes to the same hop")
+	fs.DurationVar(&f.cfg.Timeout, "t", time.Duration(3*time.Second), "The maximum time to wait for an answer")
+	fs.IntVar(&f.cfg.TOS, "TOS", 0, "The TOS (Type of Service) field in the ip header")
+	fs.Func("I", "The source address of the probes, or the name of the interface they are sent through, as traceroute -i", sourceFlag(&f.cfg))
+	fs.IntVar(&f.cfg.Mark, "mark", 0, "The mark of the packets, matched by the policy routing rules and the firewall. Needs CAP_NET_ADMIN")
+	fs.StringVar(&f.netns, "netns", "", "The network namespace the probes are sent from, named by ip netns or given by its path. Needs CAP_SYS_ADMIN")
+	fs.BoolVar(&f.v6, "6", false, "Probe IPv6 hosts with ICMPv6")
+	fs.BoolVar(&f.unprivileged, "unprivileged", false, "Probe IPv4 hosts with ping sockets. Does not need root, but the group must be in net.ipv4.ping_group_range")
+}
+
+//goPinger returns the GoPinger configured by the flags
+func (f *pathFlags) goPinger() goping.GoPinger {
+	var pinger = icmpv4.New(icmpv4.Namespace(f.netns))
+	if f.v6 {
+		pinger = icmpv6.New()
+	} else if f.unprivileged {
+		pinger = icmpv4.NewUnprivileged(icmpv4.Namespace(f.netns))
+	}
+	return goping.New(f.cfg, pinger, nil, nil)
+}
+
+//interruptible returns a context that is cancelled when the program is interrupted
+func interruptible() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+//traceroute runs the traceroute subcommand with its own flags: goping traceroute [flags] host
+func traceroute(args []string) {
+	var f pathFlags
+	fs := flag.NewFlagSet("traceroute", flag.ExitOnError)
+	f.register(fs, 3, time.Duration(100*time.Millisecond))
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintf(fs.Output(), "Usage: %v traceroute [flags] host\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	host := fs.Arg(0)
+
+	//Stops the trace when the program is interrupted. The hops found so far are printed
+	ctx, cancel := interruptible()
+	defer cancel()
+
+	fmt.Printf("traceroute to %v, %v hops max, %v probes per hop\n", host, f.trace.MaxTTL, f.trace.Probes)
+	path, err := goping.Trace(ctx, f.goPinger(), host, f.trace)
+	for _, h := range path.Hops {
+		printHop(h)
+	}
+	if err != nil {
+		log.Fatalf("Trace stopped: %v", err)
+	}
+}
+
+//printHop prints a line with the addresses of the hop and its RTT statistics, or * when nothing answered
+func printHop(h goping.Hop) {
+	if len(h.Peers) == 0 {
+		fmt.Printf("%2d  *\n", h.TTL)
+		return
+	}
+	rtt := "rtt min/avg/max = */*/* ms"
+	if !math.IsNaN(h.Stats.Avg) {
+		rtt = fmt.Sprintf("rtt min/avg/max = %.3f/%.3f/%.3f ms", h.Stats.Min, h.Stats.Avg, h.Stats.Max)
+	}
+	var end string
+	if h.Err != nil {
+		end = "!" + h.Err.Error()
+	}
+	fmt.Printf("%2d  %-15v  %5.1f%% loss  %v  %v\n", h.TTL, peers(h), h.Stats.Loss, rtt, end)
+}
+
+//peers returns the addresses that answered at the hop separated by spaces
+func peers(h goping.Hop) string {
+	list := make([]string, len(h.Peers))
+	for i, p := range h.Peers {
+		list[i] = p.String()
+	}
+	return strings.Join(list, " ")
+}
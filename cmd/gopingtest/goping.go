@@ -14,7 +14,7 @@ func main() {
 	cfg := goping.Config{
 		Count:      10,                             //a negative number will ping forever
 		Interval:   time.Duration(1 * time.Second), //The interval between a host ping
-		PacketSize: 100,                            //The packet size. It is nos implemented correctly yet. Now only local time are being send in the ping packet
+		PacketSize: 100,                            //The size of the echo data. The send timestamp is followed by zeros
 		TOS:        0,                              //Type Of Sevice being passed. Only for linux and mac
 		TTL:        64,                             //Time-To-Live, Only for Linux and Mac
 		Timeout:    time.Duration(3 * time.Second), //The max time to wait for an answer
@@ -1,10 +1,10 @@
 package goping
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"net"
-	"os"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,12 +14,34 @@ import (
 
 //Config is the configures a GoPing object
 type Config struct {
-	Count      int
-	Interval   time.Duration
-	Timeout    time.Duration
-	TOS        int
-	TTL        int
+	Count    int
+	Interval time.Duration
+	Timeout  time.Duration
+	TOS      int
+	TTL      int
+	//PacketSize is the size of the data sent in each ping, as the -s option of ping. Pingers raise it to the size of the data they need, like the send timestamp
 	PacketSize int
+	//FlowID, when greater than 0, asks the pinger to keep constant the fields that load balancers hash for every ping with the same FlowID,
+	//so they follow the same path as in Paris traceroute. Only honored by the raw ICMPv4 pinger on linux
+	FlowID int
+	//DontFragment sets the Don't Fragment bit of the IPv4 header. Routers drop the packets larger than the MTU of their next hop and answer Fragmentation Needed.
+	//Packets larger than the MTU of the local interface fail with ErrMessageTooLong. Honored by the icmpv4 pingers
+	DontFragment bool
+	//Identifiers is the number of ICMP identifiers a session takes from DefaultIdentifiers. The hosts are spread over them, so each shard of hosts has its own identifier.
+	//Only read from the Config given to New. Default 1
+	Identifiers int
+	//Source is the source address of the pings. It must be an address of the host, and of the VRF of Interface when it is set.
+	//nil lets the kernel choose the address of the route to the host. Honored by the icmpv4 pingers on linux
+	Source net.IP
+	//Interface is the name of the network interface the pings are sent through, as SO_BINDTODEVICE, ignoring the routes through the others.
+	//Empty sends them through the interface of the route to the host. Honored by the icmpv4 pingers on linux
+	Interface string
+	//Mark is the mark of the packets, as SO_MARK, matched by the policy routing rules and the firewall. 0 does not mark them.
+	//Needs CAP_NET_ADMIN. Honored by the icmpv4 pingers on linux
+	Mark int
+	//Namespace is the network namespace the pings are sent from, named by ip netns or given by its path, as /var/run/netns/blue.
+	//Source and Interface belong to it. Empty sends them from the namespace of the pinger. Honored by the icmpv4 pingers on linux
+	Namespace string
 }
 
 //Request represents a Ping Job. A request can generate 1 to Count responses
@@ -37,21 +59,83 @@ type Request struct {
 type SeqRequest struct {
 	Seq int
 	Req Request
+	Dst net.IP //The address of Req.Host resolved by the session. nil when the pinger does not implement Resolver
+	ID  int    //The ICMP identifier of the ping, owned by the session
 }
 
-//Response is sent for each Request Count iteration
+//Response is sent for each Request Count iteration.
+//Duplicate and late replies are sent as extra responses, after the response of their ping, with the Request as it was when the ping was sent
 type Response struct {
 	Request Request
 	RawResponse
+	Duplicate bool //The reply answers a ping that was already answered, as the DUP! of ping
+	Late      bool //The reply arrived after the ping timed out, which was already responded with ErrTimeout
 }
 
 //RawResponse represents the response send back by a Pinger
 type RawResponse struct {
 	Seq         int
-	RTT         float64
+	ID          int     //The ICMP identifier of the ping answered. Set by the pingers that implement Resolver
+	RTT         float64 //NaN when not measured. The RTT of an ICMP error is the time until the router that sent it answered
 	Peer        net.IP
+	Dst         net.IP //The destination of the ping answered. Set by the pingers that implement Resolver
 	ICMPMessage []byte
 	Err         error
+	Kind        ReplyKind   //How the host answered when Err is nil
+	SendStamp   StampSource //The clock that timestamped the request, for the RTT
+	RecvStamp   StampSource //The clock that timestamped the reply, for the RTT
+	//The send timestamp echoed in the reply is not the one the pinger sent. The RTT does not depend on it.
+	//Only set by pingers that keep the send times of their requests
+	StampMismatch bool
+
+	//Duration in milliseconds of each step of the ping, keyed by step name. Only set by pingers that measure them
+	Phases map[string]float64
+}
+
+//ReplyKind tells how a host answered a ping
+type ReplyKind int
+
+const (
+	//ReplyEcho is an answer from the probed host or service, like an ICMP echo reply or an accepted TCP connection
+	ReplyEcho ReplyKind = iota
+	//ReplyRefused tells the host is reachable but refused the probe, like a TCP reset or an ICMP port unreachable
+	ReplyRefused
+)
+
+func (k ReplyKind) String() string {
+	switch k {
+	case ReplyEcho:
+		return "echo"
+	case ReplyRefused:
+		return "refused"
+	default:
+		return "unknown"
+	}
+}
+
+//StampSource tells which clock timestamped a ping when it was sent or received. The RTT is only as precise as its timestamps
+type StampSource int
+
+const (
+	//StampUser is the clock read by the process before sending or after receiving. Scheduling delays and GC pauses add to the RTT
+	StampUser StampSource = iota
+	//StampKernel is the software timestamp taken by the kernel when the packet crossed the network stack
+	StampKernel
+	//StampHardware is the timestamp taken by the network card. Both timestamps of an RTT come from the same clock
+	StampHardware
+)
+
+func (s StampSource) String() string {
+	switch s {
+	case StampUser:
+		return "user"
+	case StampKernel:
+		return "kernel"
+	case StampHardware:
+		return "hardware"
+	default:
+		return "unknown"
+	}
 }
 
 /*** Interfaces ***/
@@ -62,6 +146,12 @@ type GoPinger interface {
 	NewRequest(hostname string, userData map[string]string) Request
 	//Start initiates the request and response channels to which requests are sent and responses are received
 	Start(smoothDuration time.Duration) (chan<- Request, <-chan Response, error)
+	//StartContext is like Start but the session is stopped when the context is cancelled
+	StartContext(ctx context.Context, smoothDuration time.Duration) (chan<- Request, <-chan Response, error)
+	//Cancel stops the running requests with the given id. Returns the number of requests cancelled
+	Cancel(id uint64) int
+	//CancelMatching stops the running requests whose UserData contains every key and value of selector. Returns the number of requests cancelled
+	CancelMatching(selector map[string]string) int
 }
 
 /*** Interface Implementation ***/
@@ -70,6 +160,7 @@ type goping struct {
 	pinger Pinger
 	idGen  IDGenerator
 	seqGen SequenceGenerator
+	jobs   *jobRegistry
 }
 
 //NewRequest creates a new request object. Uses an id generator to populate the Id field
@@ -82,38 +173,188 @@ func (g goping) NewRequest(hostname string, userData map[string]string) Request
 	}
 }
 
+//Cancel stops the running requests with the given id in every session.
+//Each cancelled request emits a last Response with ErrCancelled and is not sent to the pinger anymore
+func (g goping) Cancel(id uint64) int {
+	return g.jobs.cancel(func(r Request) bool {
+		return r.ID == id
+	})
+}
+
+//CancelMatching stops the running requests whose UserData contains every key and value of selector.
+//An empty selector matches every running request
+func (g goping) CancelMatching(selector map[string]string) int {
+	return g.jobs.cancel(func(r Request) bool {
+		for k, v := range selector {
+			if ud, ok := r.UserData[k]; !ok || ud != v {
+				return false
+			}
+		}
+		return true
+	})
+}
+
 //Start initiates the request and response channels to which requests are sent and responses are received
 func (g goping) Start(smoothDuration time.Duration) (chan<- Request, <-chan Response, error) {
+	return g.StartContext(context.Background(), smoothDuration)
+}
+
+//StartContext is like Start but binds the session to ctx.
+//When ctx is cancelled no new pings are scheduled, every running request emits a last Response with ErrCancelled,
+//the pinger is stopped and the response channel is closed. Requests sent after the cancellation are discarded.
+func (g goping) StartContext(ctx context.Context, smoothDuration time.Duration) (chan<- Request, <-chan Response, error) {
 	if smoothDuration <= 0 {
 		return nil, nil, fmt.Errorf("smoothDuration should be greater than 0. Actual value %v", smoothDuration)
 	}
 	//Receives requests from the caller and send to "pin"
 	in := make(chan Request)
 	//Receives requests from "in" or "pin" and send to "pin" or "out"
-	pin := make(chan Request)
+	pin := make(chan job)
 	//Receives responses from "pin" . Caller consumes
 	out := make(chan Response)
-	//Receives signal from the caller and starts a goroutine that waits the pinger shutdown and send signal to done
-	doneIn := make(chan struct{})
-	//Receivex signal from goroutine inside doneIn and return the function
-	done := make(chan struct{})
-	//Used to wait for all ping requests to finish
-	var wg sync.WaitGroup
+	//Receives each request job that is finished
+	finished := make(chan job)
 
+	//Takes the ICMP identifiers of the session. They are released when the session finishes
+	nidents := g.cfg.Identifiers
+	if nidents < 1 {
+		nidents = 1
+	}
+	idents, err := DefaultIdentifiers.acquire(nidents)
+	if err != nil {
+		return nil, nil, err
+	}
 	//Start the pinger channels
-	ping, pong, pongdone, err := g.pinger.Start(os.Getpid())
+	ping, pong, pongdone, err := g.pinger.Start(idents[0])
 	if err != nil {
+		DefaultIdentifiers.release(idents)
 		return nil, nil, fmt.Errorf("Could not start pinger: [%v]", err)
 	}
 
+	//Emits the last response of a cancelled request job and signals that the job is finished.
+	//seq is the sequence of the ping that was being waited or -1 if there was none
+	cancel := func(j job, seq int) {
+		out <- Response{
+			Request:     j.Request,
+			RawResponse: RawResponse{Seq: seq, RTT: math.NaN(), Err: ErrCancelled},
+		}
+		finished <- j
+	}
+
 	//Start the main loop in a goroutine.
 	go func(in chan Request, out chan Response, ping chan<- SeqRequest, pong <-chan RawResponse, pongdone <-chan struct{}) {
-		//This slice will hold the responses channels of a request.indexed by the icmp sequence number
-		holder := make(map[int]chan RawResponse)
+		//This map will hold the pings sent, indexed by their destination and icmp sequence number.
+		//A ping is kept after it is resolved to recognize its duplicate and late replies, for the Timeout of its request or until its sequence is used again
+		holder := make(map[probeKey]*waiter)
+		//The resolved pings kept in holder, in the order they were resolved
+		var lingering []*waiter
+		//Keeps a resolved ping in holder for the Timeout of its request
+		linger := func(w *waiter) {
+			w.until = time.Now().Add(w.req.Config.Timeout)
+			lingering = append(lingering, w)
+		}
+		//Removes from holder the resolved pings kept long enough
+		sweep := func() {
+			now := time.Now()
+			for len(lingering) > 0 && now.After(lingering[0].until) {
+				if w := lingering[0]; holder[w.key] == w {
+					delete(holder, w.key)
+				}
+				lingering[0] = nil
+				lingering = lingering[1:]
+			}
+		}
+		//The sequences of the destinations with pings waiting for a reply
+		spaces := make(map[seqTarget]*seqSpace)
+		//Tells whether the ping with the key is waiting for a reply
+		busy := func(key probeKey) bool {
+			w := holder[key]
+			return w != nil && !w.replied && !w.expired
+		}
+		//Allocates the sequence of the ping with the key. The sequence of the generator is used when it is free,
+		//otherwise a free one is taken from the space of the destination. Returns false when every sequence is in flight
+		allocate := func(key probeKey, rid uint64) (int, bool) {
+			target := seqTarget{dst: key.dst, ident: key.ident}
+			space := spaces[target]
+			if space == nil {
+				space = &seqSpace{freed: make(map[int]struct{})}
+				spaces[target] = space
+			}
+			key.seq = g.seqGen.Next(rid)
+			if busy(key) {
+				var ok bool
+				if key.seq, ok = space.free(func(seq int) bool { return busy(probeKey{dst: key.dst, ident: key.ident, seq: seq}) }); !ok {
+					return 0, false
+				}
+			}
+			delete(space.freed, key.seq)
+			space.inflight++
+			return key.seq, true
+		}
+		//Gives back the sequence of a ping that stops waiting for a reply
+		release := func(w *waiter) {
+			target := seqTarget{dst: w.key.dst, ident: w.key.ident}
+			space := spaces[target]
+			if holder[w.key] != w || space == nil {
+				return
+			}
+			if space.inflight--; space.inflight == 0 {
+				//Every sequence of the destination is free
+				delete(spaces, target)
+			} else if w.key.seq < space.next {
+				space.freed[w.key.seq] = struct{}{}
+			}
+		}
+		//Receives the pings that timed out or were cancelled
+		settled := make(chan settlement)
+		//Number of duplicate and late responses being sent, and the signal that one was sent
+		extra := 0
+		delivered := make(chan struct{})
+		//Sends a duplicate or late response without blocking the main loop, after the response of its ping
+		emit := func(w *waiter, resp Response) {
+			extra++
+			go func() {
+				<-w.responded
+				out <- resp
+				delivered <- struct{}{}
+			}()
+		}
+		//True after the ping channel is closed, and after the pinger is finished
+		closed, drained := false, false
 		//Create the time slots between ping requests
 		tick := time.NewTicker(smoothDuration)
+		defer tick.Stop()
+		//Number of request jobs still running
+		pending := 0
+		//Receives the cancellation signal. It is set to nil once received
+		cancelled := ctx.Done()
+		//Receives the pinger shutdown signal. It is set only after the ping channel is closed
+		var stopped <-chan struct{}
 		//The main loop
 		for {
+			//Verifies if no more requests will be processed and all jobs are done
+			if (in == nil || ctx.Err() != nil) && pending == 0 && !closed {
+				//Signals pinger that no more requests will be sent
+				close(ping)
+				closed = true
+				//Waits pinger finish all tasks
+				stopped = pongdone
+			}
+			//The pinger is finished and every response was sent. We can exit the function
+			if drained && extra == 0 {
+				//The replies to the identifiers of the session are not expected anymore
+				DefaultIdentifiers.release(idents)
+				//Signals user that no more responses will be sent
+				close(out)
+				//Discards requests the caller still sends after a cancellation
+				if in != nil {
+					go func(in <-chan Request) {
+						for range in {
+						}
+					}(in)
+				}
+				return
+			}
 			//Channel selection
 			select {
 			//Received a Request from Client
@@ -122,53 +363,113 @@ func (g goping) Start(smoothDuration time.Duration) (chan<- Request, <-chan Resp
 				if !open {
 					//Stop reading from channel
 					in = nil
+				} else if ctx.Err() == nil && recv.Config.Count != 0 {
+					//Session is running and we have pings to do for this request based on the Count field
+					pending++
+					//Registers the job so it can be cancelled by Cancel or CancelMatching
+					jctx, jcancel := context.WithCancel(ctx)
+					j := job{Request: recv, ctx: jctx, key: g.jobs.add(recv, jcancel), ident: shard(recv.Host, idents)}
+					//Send request to be processed in a goroutine to not block this for loop
 					go func() {
-						//Send signal to doneIn because channel is closed
-						doneIn <- struct{}{}
+						//Resolves the host once for every ping of the job
+						if r, ok := g.pinger.(Resolver); ok {
+							j.dst, j.rerr = r.Resolve(j.Host)
+						}
+						pin <- j
 					}()
-				} else {
-					//incrementing WaitGroup for later synchronization
-					wg.Add(1)
-					//Verifies if we have pings to do for this request based on the Count field
-					if recv.Config.Count == 0 {
-						//Request  Count is 0. Job is done without sending any requests
-						wg.Done()
-					} else {
-						//Send request to be processed in a goroutine to not block this for loop
-						go func() {
-							pin <- recv
-						}()
-					}
 				}
 			//Received RawResponse from Pinger
 			case rresp, open := <-pong:
 				//Verifies if pong channel is open
-				if open {
-					//Verifies if holder has a respchan in the sequence nubuer provided by the response
-					if holder[rresp.Seq] != nil {
+				if !open {
+					//Stop reading from channel
+					pong = nil
+				} else if w := holder[keyOf(rresp.Dst, rresp.ID, rresp.Seq)]; w != nil {
+					switch {
+					case w.replied:
+						//The ping was already answered
+						emit(w, Response{Request: w.req, RawResponse: rresp, Duplicate: true, Late: w.expired})
+					case w.expired:
+						//The ping was already responded with a timeout
+						w.replied = true
+						emit(w, Response{Request: w.req, RawResponse: rresp, Late: true})
+					default:
 						//Sends the raw response to the respchan.
 						//It will be catch inside goroutine that waits for the response
 						//The response channel has a buffer of size 1. So this will no block the for loop
-						holder[rresp.Seq] <- rresp
-						//Deletes the map entry
-						delete(holder, rresp.Seq)
+						release(w)
+						w.replied = true
+						w.ch <- rresp
+						linger(w)
 					}
 				}
+			//Received a ping that timed out or was cancelled
+			case st := <-settled:
+				w := st.w
+				switch {
+				case st.err != ErrTimeout:
+					//Replies to a cancelled ping are not expected anymore
+					if !w.replied && !w.expired {
+						release(w)
+					}
+					if holder[w.key] == w {
+						delete(holder, w.key)
+					}
+				case w.replied:
+					//The reply arrived while the timeout was being responded. It was not read from the respchan
+					w.expired = true
+					emit(w, Response{Request: w.req, RawResponse: <-w.ch, Late: true})
+				default:
+					release(w)
+					w.expired = true
+					linger(w)
+				}
+			//Received signal that a duplicate or late response was sent
+			case <-delivered:
+				extra--
 			//Received Request from in or pin
 			case recv := <-pin:
+				//Verifies if the session or the request was cancelled while the request was waiting
+				if recv.ctx.Err() != nil {
+					go cancel(recv, -1)
+					break
+				}
 				//Incrementing Request Sent Counter
 				recv.Sent++
-				//Create the SeqRequest struct
-				sr := SeqRequest{Seq: g.seqGen.Next(recv.ID), Req: recv}
+				//The pings to a host that could not be resolved fail without being sent
+				failed := recv.rerr
+				//Create the SeqRequest struct. Its sequence is -1 when the ping is not sent
+				sr := SeqRequest{Seq: -1, Req: recv.Request, Dst: recv.dst, ID: recv.ident}
 				//Creates a channel to receive the response
-				respchan := make(chan RawResponse, 1)
-				//Stores the channel in a slice indexed by the icmp sequence number
-				holder[sr.Seq] = respchan
+				w := &waiter{ch: make(chan RawResponse, 1), responded: make(chan struct{}), req: recv.Request}
+				respchan := w.ch
+				if failed == nil {
+					//Removes the resolved pings kept long enough before looking for a free sequence
+					sweep()
+					w.key = keyOf(recv.dst, recv.ident, 0)
+					if seq, ok := allocate(w.key, recv.ID); ok {
+						sr.Seq, w.key.seq = seq, seq
+						//Stores the ping in a map indexed by its destination and icmp sequence number
+						holder[w.key] = w
+					} else {
+						failed = ErrWindowExhausted
+					}
+				}
 				//Send the request to the Pinger ping channel
 				go func() {
-					//Waits for the smooth interval inside the goroutine
-					<-tick.C
-					ping <- sr
+					if failed == nil {
+						//Waits for the smooth interval inside the goroutine
+						select {
+						case <-tick.C:
+						case <-recv.ctx.Done():
+							//The ping was not sent yet
+							settled <- settlement{w: w, err: ErrCancelled}
+							close(w.responded)
+							cancel(recv, -1)
+							return
+						}
+						ping <- sr
+					}
 					//Start a goroutinr to wait for the response
 					go func(sr SeqRequest, respchan <-chan RawResponse) {
 						//Schedule the wait interval for the next ping
@@ -177,52 +478,62 @@ func (g goping) Start(smoothDuration time.Duration) (chan<- Request, <-chan Resp
 						timeout := time.After(recv.Config.Timeout)
 						//Builds the response object
 						resp := Response{
-							Request:     recv,
+							Request:     recv.Request,
 							RawResponse: RawResponse{Seq: sr.Seq, RTT: math.NaN()},
 						}
-						//Receive response or timeout
-						select {
-						case <-timeout:
-							//Assign timeout error to response
-							resp.Err = ErrTimeout
-						case r := <-respchan:
-							//Assign RawResponse to Response
-							resp.RawResponse = r
+						//Receive response, timeout or cancellation
+						if failed != nil {
+							//The ping was not sent
+							resp.Err = failed
+						} else {
+							select {
+							case <-timeout:
+								//Assign timeout error to response. A reply arriving later is sent as a late response
+								resp.Err = ErrTimeout
+								settled <- settlement{w: w, err: ErrTimeout}
+							case r := <-respchan:
+								//Assign RawResponse to Response
+								resp.RawResponse = r
+							case <-recv.ctx.Done():
+								//Assign cancelled error to response
+								resp.Err = ErrCancelled
+								settled <- settlement{w: w, err: ErrCancelled}
+							}
 						}
 						//Send response to out channel. Blocks this function until the client consumes the response
-						//We block because of the synchronization with waitgroup. Otherwise we would write to a closed channel.
+						//We block because the main loop only closes the out channel after all jobs are finished.
 						out <- resp
+						close(w.responded)
 						//Verifies if we have more pings to do for this request
-						if recv.Config.Count >= 0 && int(recv.Sent) >= recv.Config.Count {
+						if resp.Err == ErrCancelled || (recv.Config.Count >= 0 && int(recv.Sent) >= recv.Config.Count) {
 							//This was the last last ping for this request. Job Done
-							wg.Done()
-						} else {
-							//We still have more pings to do. Wait for the interval timer before send another request to pin channel
-							<-waitInterval
-							//Send another request to pin
-							pin <- recv
+							finished <- recv
+							return
 						}
+						//We still have more pings to do. Wait for the interval timer before send another request to pin channel
+						select {
+						case <-waitInterval:
+						case <-recv.ctx.Done():
+							cancel(recv, -1)
+							return
+						}
+						//Send another request to pin
+						pin <- recv
 					}(sr, respchan)
 
 				}()
-			//Received signal that the "in" channel is closed. No more requests.
-			case <-doneIn:
-				//Starts a goroutine to wait for WaitGroup.
-				go func(wg *sync.WaitGroup, out chan Response, done chan struct{}) {
-					//Wait for all requests be finished including the timeouts
-					wg.Wait()
-					//Signals pinger that no more requests will be sent
-					close(ping)
-					//Waits pinger finish all tasks
-					<-pongdone
-					//Signals user that no more responses will be sent
-					close(out)
-					//Signals function to exit
-					done <- struct{}{}
-				}(&wg, out, done)
-			//Received signal that we can exit the function
-			case <-done:
-				return
+			//Received signal that a request job is finished
+			case j := <-finished:
+				pending--
+				g.jobs.remove(j.key)
+			//Received signal that the session was cancelled
+			case <-cancelled:
+				cancelled = nil
+				//The goroutines waiting for responses are resolved by the context. No need to hold their channels
+				holder, lingering, spaces = make(map[probeKey]*waiter), nil, make(map[seqTarget]*seqSpace)
+			//Received signal that the pinger is finished
+			case <-stopped:
+				stopped, drained = nil, true
 			}
 		}
 	}(in, out, ping, pong, pongdone)
@@ -244,6 +555,7 @@ func New(cfg Config, pinger Pinger, seqGen SequenceGenerator, idGen IDGenerator)
 		pinger: pinger,
 		seqGen: seqGen,
 		idGen:  idGen,
+		jobs:   &jobRegistry{jobs: make(map[uint64]jobEntry)},
 	}
 }
 
@@ -287,3 +599,129 @@ func defaultIDGen() IDGenerator {
 	defIDGenOnce.Do(func() { defIDGen = new(idGenerator) })
 	return defIDGen
 }
+
+//seqWindow is the number of ICMP sequences. A destination can not have more pings waiting for a reply
+const seqWindow = 65536
+
+//probeKey identifies a ping sent by a session. The pinger only delivers the replies to the ICMP identifiers of the session
+type probeKey struct {
+	dst   string //The resolved destination as 16 bytes. Empty when the pinger does not implement Resolver
+	ident int    //The ICMP identifier of the ping. Zero when the pinger does not implement Resolver
+	seq   int
+}
+
+//keyOf returns the key of the ping to the resolved destination dst with the ICMP identifier ident and the sequence seq
+func keyOf(dst net.IP, ident, seq int) probeKey {
+	if dst == nil {
+		//The pinger does not tell the destination and the identifier of its replies
+		return probeKey{seq: seq}
+	}
+	return probeKey{dst: string(dst.To16()), ident: ident, seq: seq}
+}
+
+//seqTarget is the destination and the ICMP identifier the sequences of a session are allocated for
+type seqTarget struct {
+	dst   string
+	ident int
+}
+
+//seqSpace finds the free sequences of a seqTarget without scanning the sequences in flight.
+//It is kept while the target has pings waiting for a reply
+type seqSpace struct {
+	next     int              //The sequences from next on were not taken by free yet
+	freed    map[int]struct{} //The sequences below next given back while other pings were in flight
+	inflight int              //The number of pings waiting for a reply
+}
+
+//free returns a sequence that is not busy. Each sequence is checked at most once by the cursor, and again only after it is given back.
+//Returns false when every sequence is busy
+func (s *seqSpace) free(busy func(seq int) bool) (int, bool) {
+	for seq := range s.freed {
+		delete(s.freed, seq)
+		if !busy(seq) {
+			return seq, true
+		}
+	}
+	for s.next < seqWindow {
+		seq := s.next
+		s.next++
+		if !busy(seq) {
+			return seq, true
+		}
+	}
+	return 0, false
+}
+
+//waiter is a ping sent by a session
+type waiter struct {
+	key       probeKey
+	ch        chan RawResponse //Receives the reply of the ping
+	responded chan struct{}    //Closed after the response of the ping is sent
+	req       Request          //The request when the ping was sent
+	replied   bool             //True after a reply was received
+	expired   bool             //True after the ping timed out
+	until     time.Time        //The time the ping is removed after it is resolved
+}
+
+//settlement tells the main loop that a ping timed out or was cancelled
+type settlement struct {
+	w   *waiter
+	err error
+}
+
+//job is a Request being processed by a session
+type job struct {
+	Request
+	ctx   context.Context //Cancelled when the session or the request is cancelled
+	key   uint64          //The key of the job in the jobRegistry
+	dst   net.IP          //The address of the host resolved by the Resolver of the pinger
+	rerr  error           //The error resolving the host
+	ident int             //The ICMP identifier of the pings of the job
+}
+
+//jobEntry holds what is needed to find and cancel a running job
+type jobEntry struct {
+	req    Request
+	cancel context.CancelFunc
+}
+
+//jobRegistry keeps the running jobs of all sessions of a GoPinger
+type jobRegistry struct {
+	mu   sync.Mutex
+	last uint64
+	jobs map[uint64]jobEntry
+}
+
+//add registers a job and returns its key
+func (r *jobRegistry) add(req Request, cancel context.CancelFunc) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last++
+	r.jobs[r.last] = jobEntry{req: req, cancel: cancel}
+	return r.last
+}
+
+//remove releases the context of a finished job and unregisters it if it was not cancelled before
+func (r *jobRegistry) remove(key uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.jobs[key]; ok {
+		e.cancel()
+		delete(r.jobs, key)
+	}
+}
+
+//cancel cancels the jobs whose request matches. Returns the number of jobs cancelled
+func (r *jobRegistry) cancel(match func(Request) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for key, e := range r.jobs {
+		if match(e.req) {
+			e.cancel()
+			delete(r.jobs, key)
+			n++
+		}
+	}
+	return n
+}
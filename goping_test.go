@@ -1,10 +1,12 @@
 package goping
 
 import (
+	"context"
 	"errors"
 	"math"
 	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -231,3 +233,376 @@ func TestGopinger(t *testing.T) {
 		t.Errorf("There are remaining answers not consumed: %v", pinger.answers)
 	}
 }
+
+func TestStartContextCancel(t *testing.T) {
+	cfg := Config{Count: -1, Interval: time.Duration(10 * time.Millisecond), PacketSize: 56, TOS: 16, TTL: 64, Timeout: time.Duration(20 * time.Millisecond)}
+	g := New(cfg, &mockPinger{}, &mockSeqGen{seqmap: make(map[uint64]int)}, &mockIDGen{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ping, pong, err := g.StartContext(ctx, time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+
+	//Requests with Count -1 would ping forever if the session was not cancelled
+	go func() {
+		for i := 0; i < 3; i++ {
+			ping <- g.NewRequest("hostname"+strconv.Itoa(i+1), nil)
+		}
+	}()
+
+	var received int
+	var cancelled = make(map[uint64]int)
+	var deadline = time.After(5 * time.Second)
+	for {
+		select {
+		case r, open := <-pong:
+			if !open {
+				if len(cancelled) != 3 {
+					t.Errorf("Every request should emit a cancelled response. Got: %v", cancelled)
+				}
+				for id, n := range cancelled {
+					if n != 1 {
+						t.Errorf("Request %v emitted %v cancelled responses. Expected: 1", id, n)
+					}
+				}
+				return
+			}
+			received++
+			if received == 10 {
+				cancel()
+			}
+			if r.Err == ErrCancelled {
+				cancelled[r.Request.ID]++
+				if !math.IsNaN(r.RTT) {
+					t.Errorf("RTT should be NaN on cancelled responses")
+				}
+			}
+		case <-deadline:
+			t.Fatalf("Response channel was not closed after the context was cancelled")
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	cfg := Config{Count: -1, Interval: time.Duration(10 * time.Millisecond), PacketSize: 56, TOS: 16, TTL: 64, Timeout: time.Duration(20 * time.Millisecond)}
+	g := New(cfg, &mockPinger{}, &mockSeqGen{seqmap: make(map[uint64]int)}, &mockIDGen{})
+
+	ping, pong, err := g.Start(time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+	teams := []string{"a", "a", "b"}
+	go func() {
+		for _, team := range teams {
+			ping <- g.NewRequest("hostname", map[string]string{"team": team})
+		}
+		close(ping) //<- Requests with Count -1 only finish when cancelled
+	}()
+
+	var seen = make(map[uint64]bool)
+	var cancelled = make(map[uint64]int)
+	var deadline = time.After(5 * time.Second)
+	for {
+		select {
+		case r, open := <-pong:
+			if !open {
+				if len(cancelled) != len(teams) {
+					t.Errorf("Every request should emit a cancelled response. Got: %v", cancelled)
+				}
+				return
+			}
+			if r.Err == ErrCancelled {
+				cancelled[r.Request.ID]++
+				if cancelled[r.Request.ID] > 1 {
+					t.Errorf("Request %v emitted responses after being cancelled", r.Request.ID)
+				}
+				continue
+			}
+			if cancelled[r.Request.ID] > 0 {
+				t.Errorf("Request %v emitted responses after being cancelled", r.Request.ID)
+			}
+			if seen[r.Request.ID] {
+				continue
+			}
+			seen[r.Request.ID] = true
+			if len(seen) == len(teams) {
+				if n := g.CancelMatching(map[string]string{"team": "a"}); n != 2 {
+					t.Errorf("CancelMatching Expected: [%v] Got: [%v]", 2, n)
+				}
+				if n := g.Cancel(3); n != 1 {
+					t.Errorf("Cancel Expected: [%v] Got: [%v]", 1, n)
+				}
+				if n := g.Cancel(3); n != 0 {
+					t.Errorf("Cancel of a cancelled request Expected: [%v] Got: [%v]", 0, n)
+				}
+			}
+		case <-deadline:
+			t.Fatalf("Response channel was not closed after the requests were cancelled")
+		}
+	}
+}
+
+//dupPinger answers the first ping twice and the second ping after the timeout
+type dupPinger struct {
+	late time.Duration
+}
+
+func (m *dupPinger) Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, donepong <-chan struct{}, err error) {
+	in, out, done := make(chan SeqRequest), make(chan RawResponse), make(chan struct{})
+	go func() {
+		n := 0
+		for r := range in {
+			n++
+			switch n {
+			case 1:
+				out <- RawResponse{Seq: r.Seq, RTT: 1}
+				out <- RawResponse{Seq: r.Seq, RTT: 2}
+			case 2:
+				time.Sleep(m.late)
+				out <- RawResponse{Seq: r.Seq, RTT: float64(m.late / time.Millisecond)}
+			}
+		}
+		close(done)
+	}()
+	return in, out, done, nil
+}
+
+func TestDuplicateAndLate(t *testing.T) {
+	cfg := Config{Count: 2, Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	g := New(cfg, &dupPinger{late: 60 * time.Millisecond}, &mockSeqGen{seqmap: make(map[uint64]int)}, &mockIDGen{})
+	ping, pong, err := g.Start(time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+	ping <- g.NewRequest("hostname", nil)
+	close(ping)
+
+	var got []Response
+	for r := range pong {
+		got = append(got, r)
+	}
+	tt := []struct {
+		sent      int
+		duplicate bool
+		late      bool
+		rtt       float64
+		err       error
+	}{
+		{sent: 1, rtt: 1},
+		{sent: 1, duplicate: true, rtt: 2},
+		{sent: 2, rtt: math.NaN(), err: ErrTimeout},
+		{sent: 2, late: true, rtt: 60},
+	}
+	if len(got) != len(tt) {
+		t.Fatalf("No match number of responses. Expected: [%v], Got: [%v] %v", len(tt), len(got), got)
+	}
+	for i, tc := range tt {
+		r := got[i]
+		if int(r.Request.Sent) != tc.sent || r.Duplicate != tc.duplicate || r.Late != tc.late || r.Err != tc.err || !(r.RTT == tc.rtt || math.IsNaN(r.RTT) && math.IsNaN(tc.rtt)) {
+			t.Errorf("No match response %v. Expected: [%v %v %v %v %v], Got: [%v %v %v %v %v]", i, tc.sent, tc.duplicate, tc.late, tc.rtt, tc.err, r.Request.Sent, r.Duplicate, r.Late, r.RTT, r.Err)
+		}
+	}
+}
+
+//constSeqGen returns the same sequence for every ping
+type constSeqGen int
+
+func (c constSeqGen) Next(rid uint64) int {
+	return int(c)
+}
+
+//resolverPinger answers each ping after the delay of its host, with the destination resolved by the session
+type resolverPinger struct {
+	addrs map[string]net.IP
+	delay map[string]time.Duration
+}
+
+func (m *resolverPinger) Resolve(host string) (net.IP, error) {
+	if ip, ok := m.addrs[host]; ok {
+		return ip, nil
+	}
+	return nil, errors.New("Unknown host")
+}
+
+func (m *resolverPinger) Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, donepong <-chan struct{}, err error) {
+	in, out, done := make(chan SeqRequest), make(chan RawResponse), make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for r := range in {
+			wg.Add(1)
+			go func(r SeqRequest) {
+				defer wg.Done()
+				d := m.delay[r.Req.Host]
+				time.Sleep(d)
+				out <- RawResponse{Seq: r.Seq, ID: r.ID, Peer: r.Dst, Dst: r.Dst, RTT: float64(d / time.Millisecond)}
+			}(r)
+		}
+		wg.Wait()
+		close(done)
+	}()
+	return in, out, done, nil
+}
+
+func TestCorrelateByDestination(t *testing.T) {
+	cfg := Config{Count: 1, Interval: time.Millisecond, Timeout: 500 * time.Millisecond}
+	p := &resolverPinger{
+		addrs: map[string]net.IP{"a": net.IPv4(10, 0, 0, 1), "same": net.IPv4(10, 0, 0, 1), "b": net.IPv4(10, 0, 0, 2)},
+		delay: map[string]time.Duration{"a": 60 * time.Millisecond, "same": 60 * time.Millisecond, "b": 20 * time.Millisecond},
+	}
+	//Every ping has the same sequence. Only the pings to the same destination collide, and take another free sequence
+	g := New(cfg, p, constSeqGen(7), &mockIDGen{})
+	ping, pong, err := g.Start(time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+	go func() {
+		for _, host := range []string{"a", "b", "same", "unknown"} {
+			ping <- g.NewRequest(host, nil)
+		}
+		close(ping)
+	}()
+	got := make(map[string]Response)
+	for r := range pong {
+		got[r.Request.Host] = r
+	}
+	if r := got["b"]; r.Err != nil || r.RTT != 20 || !r.Dst.Equal(p.addrs["b"]) {
+		t.Errorf("No match response of b. Expected: [<nil> 20 %v], Got: [%v %v %v]", p.addrs["b"], r.Err, r.RTT, r.Dst)
+	}
+	//One of the hosts with the same address has the sequence of the generator and the other the first free one
+	a, same := got["a"], got["same"]
+	if a.Seq != 7 {
+		a, same = same, a
+	}
+	if a.Err != nil || a.RTT != 60 || a.Seq != 7 || same.Err != nil || same.RTT != 60 || same.Seq != 0 {
+		t.Errorf("No match responses to the same address. Expected: [<nil> 60 7 <nil> 60 0], Got: [%v %v %v %v %v %v]", a.Err, a.RTT, a.Seq, same.Err, same.RTT, same.Seq)
+	}
+	//The pings to hosts that could not be resolved fail without being sent
+	if r := got["unknown"]; r.Err == nil || r.Err.Error() != "Unknown host" {
+		t.Errorf("Expected the error resolving the host. Got: %v", r.Err)
+	}
+}
+
+func TestCorrelateByIdentifier(t *testing.T) {
+	//The hosts are sharded over two identifiers: a and same have the same address and different identifiers
+	cfg := Config{Count: 1, Interval: time.Millisecond, Timeout: 500 * time.Millisecond, Identifiers: 2}
+	p := &resolverPinger{
+		addrs: map[string]net.IP{"a": net.IPv4(10, 0, 0, 1), "same": net.IPv4(10, 0, 0, 1)},
+		delay: map[string]time.Duration{"a": 60 * time.Millisecond, "same": 20 * time.Millisecond},
+	}
+	g := New(cfg, p, constSeqGen(7), &mockIDGen{})
+	ping, pong, err := g.Start(time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+	go func() {
+		for _, host := range []string{"a", "same"} {
+			ping <- g.NewRequest(host, nil)
+		}
+		close(ping)
+	}()
+	for r := range pong {
+		//The pings do not collide, so both have the sequence of the generator
+		if r.Err != nil || r.Seq != 7 || r.RTT != float64(p.delay[r.Request.Host]/time.Millisecond) {
+			t.Errorf("No match response of %v. Expected: [<nil> 7 %v], Got: [%v %v %v]", r.Request.Host, p.delay[r.Request.Host]/time.Millisecond, r.Err, r.Seq, r.RTT)
+		}
+	}
+}
+
+func TestSeqSpace(t *testing.T) {
+	busy := map[int]bool{0: true, 1: true, 3: true}
+	isBusy := func(seq int) bool { return busy[seq] || seq >= 5 }
+	s := &seqSpace{freed: make(map[int]struct{})}
+	var got []int
+	for {
+		seq, ok := s.free(isBusy)
+		if !ok {
+			break
+		}
+		busy[seq] = true
+		got = append(got, seq)
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 4 || s.next != seqWindow {
+		t.Errorf("No match free sequences. Expected: [[2 4] %v], Got: [%v %v]", seqWindow, got, s.next)
+	}
+	//A sequence given back is found again without moving the cursor
+	busy[1] = false
+	s.freed[1] = struct{}{}
+	if seq, ok := s.free(isBusy); !ok || seq != 1 {
+		t.Errorf("No match given back sequence. Expected: [1 true], Got: [%v %v]", seq, ok)
+	}
+	if _, ok := s.free(isBusy); ok {
+		t.Errorf("Every sequence should be busy")
+	}
+}
+
+//identPinger records the identifier of each ping and answers it
+type identPinger struct {
+	mu    sync.Mutex
+	pid   int
+	hosts map[string]map[int]bool
+}
+
+func (m *identPinger) Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, donepong <-chan struct{}, err error) {
+	in, out, done := make(chan SeqRequest), make(chan RawResponse), make(chan struct{})
+	m.pid, m.hosts = pid, make(map[string]map[int]bool)
+	go func() {
+		for r := range in {
+			m.mu.Lock()
+			if m.hosts[r.Req.Host] == nil {
+				m.hosts[r.Req.Host] = make(map[int]bool)
+			}
+			m.hosts[r.Req.Host][r.ID] = true
+			m.mu.Unlock()
+			out <- RawResponse{Seq: r.Seq, RTT: 1}
+		}
+		close(done)
+	}()
+	return in, out, done, nil
+}
+
+func TestSessionIdentifiers(t *testing.T) {
+	cfg := Config{Count: 2, Interval: time.Millisecond, Timeout: time.Second, Identifiers: 4}
+	p1, p2 := &identPinger{}, &identPinger{}
+	g1, g2 := New(cfg, p1, nil, nil), New(Config{Count: 1, Timeout: time.Second}, p2, nil, nil)
+	ping1, pong1, err := g1.Start(time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+	ping2, pong2, err := g2.Start(time.Duration(1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v\n", err)
+	}
+	go func() {
+		for i := 0; i < 40; i++ {
+			ping1 <- g1.NewRequest("10.0.0."+strconv.Itoa(i), nil)
+		}
+		close(ping1)
+		ping2 <- g2.NewRequest("10.0.0.1", nil)
+		close(ping2)
+	}()
+	for range pong1 {
+	}
+	for range pong2 {
+	}
+	//Every ping to a host uses the identifier of its shard, and the shards are spread over the identifiers of the session
+	ids := make(map[int]bool)
+	for host, hids := range p1.hosts {
+		if len(hids) != 1 {
+			t.Errorf("The pings to %v used identifiers %v. Expected one", host, hids)
+		}
+		for id := range hids {
+			ids[id] = true
+		}
+	}
+	if len(ids) != 4 || !ids[p1.pid] {
+		t.Errorf("Expected the 4 identifiers of the session, from %v. Got: %v", p1.pid, ids)
+	}
+	//Sessions running at the same time do not share identifiers
+	for id := range p2.hosts["10.0.0.1"] {
+		if ids[id] || id != p2.pid {
+			t.Errorf("The identifier %v of the second session is also owned by the first: %v", id, ids)
+		}
+	}
+}
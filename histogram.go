@@ -0,0 +1,241 @@
+package goping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+/*** Histogram ***/
+
+//HistogramAccuracy is the maximum relative error of the quantiles estimated by a Histogram
+const HistogramAccuracy = 0.01
+
+//histogramMinValue is the smallest value counted in a logarithmic bucket. Smaller values are counted in the zero bucket
+const histogramMinValue = 1e-9
+
+var (
+	histogramGamma    = (1 + HistogramAccuracy) / (1 - HistogramAccuracy)
+	histogramLogGamma = math.Log(histogramGamma)
+
+	//ErrHistogramFormat is returned when a serialized Histogram can not be decoded
+	ErrHistogramFormat = errors.New("Invalid histogram format")
+)
+
+//Histogram is a streaming quantile sketch of RTT values. The zero value is an empty Histogram ready to use.
+//Values are counted in logarithmic buckets, so every quantile is estimated with a relative error
+//bounded by HistogramAccuracy. Histograms can be merged and serialized with encoding/json or encoding.BinaryMarshaler
+type Histogram struct {
+	buckets map[int]uint64 //Count of values by bucket index
+	zero    uint64         //Count of values smaller than histogramMinValue
+	count   uint64
+	min     float64
+	max     float64
+}
+
+//NewHistogram creates an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{buckets: make(map[int]uint64), min: math.Inf(1), max: math.Inf(-1)}
+}
+
+//Add counts a value. NaN values are ignored
+func (h *Histogram) Add(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	if h.buckets == nil {
+		h.buckets = make(map[int]uint64)
+	}
+	if v < histogramMinValue {
+		h.zero++
+	} else {
+		h.buckets[int(math.Ceil(math.Log(v)/histogramLogGamma))]++
+	}
+	if h.count == 0 {
+		h.min, h.max = v, v
+	}
+	h.count++
+	h.min = math.Min(h.min, v)
+	h.max = math.Max(h.max, v)
+}
+
+//Merge adds the values counted by o to h. A nil o adds nothing
+func (h *Histogram) Merge(o *Histogram) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if h.buckets == nil {
+		h.buckets = make(map[int]uint64, len(o.buckets))
+	}
+	if h.count == 0 {
+		h.min, h.max = o.min, o.max
+	}
+	for i, c := range o.buckets {
+		h.buckets[i] += c
+	}
+	h.zero += o.zero
+	h.count += o.count
+	h.min = math.Min(h.min, o.min)
+	h.max = math.Max(h.max, o.max)
+}
+
+//Clone returns a copy of h
+func (h *Histogram) Clone() *Histogram {
+	c := *h
+	c.buckets = make(map[int]uint64, len(h.buckets))
+	for i, n := range h.buckets {
+		c.buckets[i] = n
+	}
+	return &c
+}
+
+//Count returns the number of values counted
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+//Quantile returns the estimated value at quantile q, between 0 and 1. Returns NaN if the histogram is empty
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.count == 0 || math.IsNaN(q) {
+		return math.NaN()
+	}
+	q = math.Max(0, math.Min(1, q))
+	//Rank of the wanted value, starting at 0
+	rank := uint64(q * float64(h.count-1))
+	if rank < h.zero {
+		return h.min
+	}
+	seen := h.zero
+	for _, i := range h.indexes() {
+		seen += h.buckets[i]
+		if seen > rank {
+			//The value that has the same relative error to both bucket limits
+			v := 2 * math.Pow(histogramGamma, float64(i)) / (histogramGamma + 1)
+			return math.Max(h.min, math.Min(h.max, v))
+		}
+	}
+	return h.max
+}
+
+//indexes returns the bucket indexes in ascending order
+func (h *Histogram) indexes() []int {
+	idx := make([]int, 0, len(h.buckets))
+	for i := range h.buckets {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+//histogramJSON is the JSON representation of a Histogram
+type histogramJSON struct {
+	Accuracy float64        `json:"accuracy"`
+	Count    uint64         `json:"count"`
+	Zero     uint64         `json:"zero"`
+	Min      float64        `json:"min"`
+	Max      float64        `json:"max"`
+	Buckets  map[int]uint64 `json:"buckets"`
+}
+
+//MarshalJSON implements json.Marshaler
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	hj := histogramJSON{Accuracy: HistogramAccuracy, Count: h.count, Zero: h.zero, Buckets: h.buckets}
+	//JSON has no representation for the infinite limits of an empty histogram
+	if h.count > 0 {
+		hj.Min, hj.Max = h.min, h.max
+	}
+	return json.Marshal(hj)
+}
+
+//UnmarshalJSON implements json.Unmarshaler
+func (h *Histogram) UnmarshalJSON(b []byte) error {
+	var hj histogramJSON
+	if err := json.Unmarshal(b, &hj); err != nil {
+		return err
+	}
+	if hj.Accuracy != HistogramAccuracy {
+		return ErrHistogramFormat
+	}
+	*h = *NewHistogram()
+	for i, c := range hj.Buckets {
+		h.buckets[i] = c
+	}
+	h.zero, h.count = hj.Zero, hj.Count
+	if h.count > 0 {
+		h.min, h.max = hj.Min, hj.Max
+	}
+	return nil
+}
+
+//histogramVersion identifies the binary format of a Histogram
+const histogramVersion = 1
+
+//MarshalBinary implements encoding.BinaryMarshaler
+func (h *Histogram) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	var vbuf [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) { buf.Write(vbuf[:binary.PutUvarint(vbuf[:], v)]) }
+	putVarint := func(v int64) { buf.Write(vbuf[:binary.PutVarint(vbuf[:], v)]) }
+
+	buf.WriteByte(histogramVersion)
+	binary.Write(&buf, binary.BigEndian, math.Float64bits(HistogramAccuracy))
+	binary.Write(&buf, binary.BigEndian, math.Float64bits(h.min))
+	binary.Write(&buf, binary.BigEndian, math.Float64bits(h.max))
+	putUvarint(h.zero)
+	putUvarint(uint64(len(h.buckets)))
+	//Indexes are written as deltas of the previous one
+	prev := 0
+	for _, i := range h.indexes() {
+		putVarint(int64(i - prev))
+		putUvarint(h.buckets[i])
+		prev = i
+	}
+	return buf.Bytes(), nil
+}
+
+//UnmarshalBinary implements encoding.BinaryUnmarshaler
+func (h *Histogram) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+	if v, err := r.ReadByte(); err != nil || v != histogramVersion {
+		return ErrHistogramFormat
+	}
+	var accuracy, min, max uint64
+	for _, v := range []*uint64{&accuracy, &min, &max} {
+		if err := binary.Read(r, binary.BigEndian, v); err != nil {
+			return ErrHistogramFormat
+		}
+	}
+	if math.Float64frombits(accuracy) != HistogramAccuracy {
+		return ErrHistogramFormat
+	}
+	n := NewHistogram()
+	n.min, n.max = math.Float64frombits(min), math.Float64frombits(max)
+	zero, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrHistogramFormat
+	}
+	n.zero, n.count = zero, zero
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return ErrHistogramFormat
+	}
+	prev := 0
+	for ; size > 0; size-- {
+		delta, err := binary.ReadVarint(r)
+		if err != nil {
+			return ErrHistogramFormat
+		}
+		c, err := binary.ReadUvarint(r)
+		if err != nil {
+			return ErrHistogramFormat
+		}
+		prev += int(delta)
+		n.buckets[prev] += c
+		n.count += c
+	}
+	*h = *n
+	return nil
+}
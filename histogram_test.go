@@ -0,0 +1,101 @@
+package goping
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func checkQuantiles(t *testing.T, name string, h *Histogram, n int) {
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 0.999, 1} {
+		expected := 1 + q*float64(n-1)
+		got := h.Quantile(q)
+		if math.Abs(got-expected)/expected > HistogramAccuracy+1e-9 {
+			t.Errorf("%v: Quantile(%v) Expected: [%v] Got: [%v]", name, q, expected, got)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	const n = 10000
+	all, even, odd := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := 1; i <= n; i++ {
+		all.Add(float64(i))
+		if i%2 == 0 {
+			even.Add(float64(i))
+		} else {
+			odd.Add(float64(i))
+		}
+	}
+	all.Add(math.NaN())
+	if all.Count() != n {
+		t.Errorf("No match Count. Expected: [%v] Got: [%v]", n, all.Count())
+	}
+	checkQuantiles(t, "all", all, n)
+
+	even.Merge(odd)
+	checkQuantiles(t, "merged", even, n)
+
+	if !math.IsNaN(NewHistogram().Quantile(0.5)) {
+		t.Errorf("Quantile of an empty histogram should be NaN")
+	}
+}
+
+func TestHistogramSerialization(t *testing.T) {
+	const n = 1000
+	h := NewHistogram()
+	h.Add(0)
+	for i := 1; i < n; i++ {
+		h.Add(float64(i) / 10)
+	}
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	fromBinary := NewHistogram()
+	if err := fromBinary.UnmarshalBinary(b); err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+
+	j, err := json.Marshal(h)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	fromJSON := NewHistogram()
+	if err := json.Unmarshal(j, fromJSON); err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+
+	for _, q := range []float64{0, 0.25, 0.5, 0.9, 0.99, 1} {
+		if h.Quantile(q) != fromBinary.Quantile(q) {
+			t.Errorf("Binary: Quantile(%v) Expected: [%v] Got: [%v]", q, h.Quantile(q), fromBinary.Quantile(q))
+		}
+		if h.Quantile(q) != fromJSON.Quantile(q) {
+			t.Errorf("JSON: Quantile(%v) Expected: [%v] Got: [%v]", q, h.Quantile(q), fromJSON.Quantile(q))
+		}
+	}
+	if err := fromBinary.UnmarshalBinary(b[:len(b)-1]); err != ErrHistogramFormat {
+		t.Errorf("Error Expected: %v Got: %v", ErrHistogramFormat, err)
+	}
+}
+
+func TestHistogramZeroValue(t *testing.T) {
+	const n = 1000
+	var h Histogram
+	for i := 1; i <= n; i++ {
+		h.Add(float64(i))
+	}
+	checkQuantiles(t, "zero value", &h, n)
+
+	var merged Histogram
+	merged.Merge(&h)
+	merged.Merge(nil)
+	merged.Merge(&Histogram{})
+	checkQuantiles(t, "merged into zero value", &merged, n)
+
+	h.Merge(nil)
+	if h.Count() != n {
+		t.Errorf("No match Count after merging nil. Expected: [%v] Got: [%v]", n, h.Count())
+	}
+}
@@ -0,0 +1,131 @@
+package goping
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+/*** ICMP Errors ***/
+
+//Protocol numbers of ICMP messages, as used by ICMPError.Proto
+const (
+	ProtocolICMP     = 1
+	ProtocolIPv6ICMP = 58
+)
+
+//ICMPError is an ICMP error message received as answer to a ping.
+//errors.Is matches it with the error of its type, like ErrDstUnreachable, and with the error of its code, like ErrPortUnreachable
+type ICMPError struct {
+	Proto    int    //ProtocolICMP or ProtocolIPv6ICMP
+	Type     int    //ICMP type
+	Code     int    //ICMP code
+	Router   net.IP //The address of the node that sent the error
+	MTU      int    //The next hop MTU of Fragmentation Needed and Packet Too Big errors. 0 when unknown
+	Original []byte //The original datagram quoted by the error, from its IP header. Pingers without access to the IP header quote only the ICMP echo
+}
+
+//icmpType identifies an ICMP type of a protocol
+type icmpType struct {
+	proto, typ int
+}
+
+//icmpCode identifies an ICMP code of a type of a protocol
+type icmpCode struct {
+	proto, typ, code int
+}
+
+//icmpTypes are the errors and names of the ICMP error types
+var icmpTypes = map[icmpType]struct {
+	err  error
+	name string
+}{
+	{ProtocolICMP, 3}:       {ErrDstUnreachable, "Destination Unreachable"},
+	{ProtocolICMP, 4}:       {ErrUnknown, "Source Quench"},
+	{ProtocolICMP, 5}:       {ErrRedirect, "Redirect Message"},
+	{ProtocolICMP, 11}:      {ErrTimeExceeded, "Time Exceeded"},
+	{ProtocolICMP, 12}:      {ErrParamProblem, "Parameter Problem"},
+	{ProtocolIPv6ICMP, 1}:   {ErrDstUnreachable, "Destination Unreachable"},
+	{ProtocolIPv6ICMP, 2}:   {ErrPacketTooBig, "Packet Too Big"},
+	{ProtocolIPv6ICMP, 3}:   {ErrTimeExceeded, "Time Exceeded"},
+	{ProtocolIPv6ICMP, 4}:   {ErrParamProblem, "Parameter Problem"},
+	{ProtocolIPv6ICMP, 137}: {ErrRedirect, "Redirect Message"},
+}
+
+//icmpCodes are the errors and names of the ICMP error codes
+var icmpCodes = map[icmpCode]struct {
+	err  error
+	name string
+}{
+	{ProtocolICMP, 3, 0}:     {ErrNetUnreachable, "Net Unreachable"},
+	{ProtocolICMP, 3, 1}:     {ErrHostUnreachable, "Host Unreachable"},
+	{ProtocolICMP, 3, 2}:     {ErrProtocolUnreachable, "Protocol Unreachable"},
+	{ProtocolICMP, 3, 3}:     {ErrPortUnreachable, "Port Unreachable"},
+	{ProtocolICMP, 3, 4}:     {ErrPacketTooBig, "Fragmentation Needed"},
+	{ProtocolICMP, 3, 5}:     {nil, "Source Route Failed"},
+	{ProtocolICMP, 3, 6}:     {ErrNetUnreachable, "Destination Network Unknown"},
+	{ProtocolICMP, 3, 7}:     {ErrHostUnreachable, "Destination Host Unknown"},
+	{ProtocolICMP, 3, 8}:     {nil, "Source Host Isolated"},
+	{ProtocolICMP, 3, 9}:     {ErrAdminProhibited, "Network Administratively Prohibited"},
+	{ProtocolICMP, 3, 10}:    {ErrAdminProhibited, "Host Administratively Prohibited"},
+	{ProtocolICMP, 3, 11}:    {ErrNetUnreachable, "Network Unreachable for TOS"},
+	{ProtocolICMP, 3, 12}:    {ErrHostUnreachable, "Host Unreachable for TOS"},
+	{ProtocolICMP, 3, 13}:    {ErrAdminProhibited, "Communication Administratively Prohibited"},
+	{ProtocolICMP, 11, 0}:    {ErrTTLExceeded, "TTL Exceeded in Transit"},
+	{ProtocolICMP, 11, 1}:    {ErrReassemblyExceeded, "Fragment Reassembly Time Exceeded"},
+	{ProtocolIPv6ICMP, 1, 0}: {ErrNetUnreachable, "No Route to Destination"},
+	{ProtocolIPv6ICMP, 1, 1}: {ErrAdminProhibited, "Communication Administratively Prohibited"},
+	{ProtocolIPv6ICMP, 1, 2}: {nil, "Beyond Scope of Source Address"},
+	{ProtocolIPv6ICMP, 1, 3}: {ErrHostUnreachable, "Address Unreachable"},
+	{ProtocolIPv6ICMP, 1, 4}: {ErrPortUnreachable, "Port Unreachable"},
+	{ProtocolIPv6ICMP, 1, 5}: {ErrAdminProhibited, "Source Address Failed Ingress/Egress Policy"},
+	{ProtocolIPv6ICMP, 1, 6}: {ErrAdminProhibited, "Reject Route to Destination"},
+	{ProtocolIPv6ICMP, 3, 0}: {ErrTTLExceeded, "Hop Limit Exceeded in Transit"},
+	{ProtocolIPv6ICMP, 3, 1}: {ErrReassemblyExceeded, "Fragment Reassembly Time Exceeded"},
+}
+
+//ParseICMPError parses the ICMP error message msg, starting at the ICMP header, sent by router.
+//proto is ProtocolICMP or ProtocolIPv6ICMP
+func ParseICMPError(proto int, router net.IP, msg []byte) (*ICMPError, error) {
+	if len(msg) < 8 {
+		return nil, errors.New("ICMP message too short")
+	}
+	e := &ICMPError{Proto: proto, Type: int(msg[0]), Code: int(msg[1]), Router: router}
+	switch {
+	case proto == ProtocolICMP && e.Type == 3 && e.Code == 4:
+		//RFC 1191. The next hop MTU is in the low 16 bits of the second word of the header
+		e.MTU = int(binary.BigEndian.Uint16(msg[6:8]))
+	case proto == ProtocolIPv6ICMP && e.Type == 2:
+		e.MTU = int(binary.BigEndian.Uint32(msg[4:8]))
+	}
+	e.Original = append([]byte(nil), msg[8:]...)
+	return e, nil
+}
+
+func (e *ICMPError) Error() string {
+	msg := fmt.Sprintf("ICMP type %d", e.Type)
+	if t, ok := icmpTypes[icmpType{e.Proto, e.Type}]; ok {
+		msg = t.name
+	}
+	if c, ok := icmpCodes[icmpCode{e.Proto, e.Type, e.Code}]; ok {
+		msg += ": " + c.name
+	} else if e.Code != 0 {
+		msg += fmt.Sprintf(": code %d", e.Code)
+	}
+	if e.MTU > 0 {
+		msg += fmt.Sprintf(" (MTU %d)", e.MTU)
+	}
+	return msg
+}
+
+//Is reports whether target is the error of the type or the error of the code of e.
+//Types without an error match ErrUnknown
+func (e *ICMPError) Is(target error) bool {
+	t, ok := icmpTypes[icmpType{e.Proto, e.Type}]
+	if !ok {
+		t.err = ErrUnknown
+	}
+	c := icmpCodes[icmpCode{e.Proto, e.Type, e.Code}]
+	return target == t.err || (c.err != nil && target == c.err)
+}
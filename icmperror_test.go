@@ -0,0 +1,71 @@
+package goping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestParseICMPError(t *testing.T) {
+	router := net.IPv4(10, 0, 0, 1)
+	quoted := []byte{0x45, 0, 0, 28}
+	tt := []struct {
+		name  string
+		proto int
+		msg   []byte
+		is    []error
+		isNot []error
+		mtu   int
+		str   string
+	}{
+		{name: "port", proto: ProtocolICMP, msg: []byte{3, 3, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrDstUnreachable, ErrPortUnreachable}, isNot: []error{ErrHostUnreachable, ErrTimeExceeded},
+			str: "Destination Unreachable: Port Unreachable"},
+		{name: "admin", proto: ProtocolICMP, msg: []byte{3, 13, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrDstUnreachable, ErrAdminProhibited}, isNot: []error{ErrNetUnreachable}},
+		{name: "fragmentation", proto: ProtocolICMP, msg: []byte{3, 4, 0, 0, 0, 0, 0x05, 0xdc},
+			is: []error{ErrDstUnreachable, ErrPacketTooBig}, mtu: 1500,
+			str: "Destination Unreachable: Fragmentation Needed (MTU 1500)"},
+		{name: "transit", proto: ProtocolICMP, msg: []byte{11, 0, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrTimeExceeded, ErrTTLExceeded}, isNot: []error{ErrReassemblyExceeded, ErrDstUnreachable}},
+		{name: "reassembly", proto: ProtocolICMP, msg: []byte{11, 1, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrTimeExceeded, ErrReassemblyExceeded}, isNot: []error{ErrTTLExceeded}},
+		{name: "unknown", proto: ProtocolICMP, msg: []byte{40, 2, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrUnknown}, str: "ICMP type 40: code 2"},
+		{name: "v6 port", proto: ProtocolIPv6ICMP, msg: []byte{1, 4, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrDstUnreachable, ErrPortUnreachable}, isNot: []error{ErrParamProblem}},
+		{name: "v6 too big", proto: ProtocolIPv6ICMP, msg: []byte{2, 0, 0, 0, 0, 0, 0x05, 0x00},
+			is: []error{ErrPacketTooBig}, mtu: 1280},
+		{name: "v6 hop limit", proto: ProtocolIPv6ICMP, msg: []byte{3, 0, 0, 0, 0, 0, 0, 0},
+			is: []error{ErrTimeExceeded, ErrTTLExceeded}},
+	}
+	for _, tc := range tt {
+		e, err := ParseICMPError(tc.proto, router, append(tc.msg, quoted...))
+		if err != nil {
+			t.Fatalf("Error not expected for %v: %v", tc.name, err)
+		}
+		//The error keeps its identity when wrapped
+		wrapped := fmt.Errorf("ping failed: %w", e)
+		for _, target := range tc.is {
+			if !errors.Is(wrapped, target) {
+				t.Errorf("Expected %v to be %v", tc.name, target)
+			}
+		}
+		for _, target := range tc.isNot {
+			if errors.Is(wrapped, target) {
+				t.Errorf("Expected %v not to be %v", tc.name, target)
+			}
+		}
+		var ie *ICMPError
+		if !errors.As(wrapped, &ie) || !ie.Router.Equal(router) || ie.MTU != tc.mtu || string(ie.Original) != string(quoted) {
+			t.Errorf("No match for %v. Got: %+v", tc.name, ie)
+		}
+		if tc.str != "" && e.Error() != tc.str {
+			t.Errorf("No match string for %v. Expected: [%v], Got: [%v]", tc.name, tc.str, e.Error())
+		}
+	}
+	if _, err := ParseICMPError(ProtocolICMP, router, []byte{3, 3, 0}); err == nil {
+		t.Errorf("Expected an error parsing a short message")
+	}
+}
@@ -0,0 +1,75 @@
+package goping
+
+import (
+	"hash/fnv"
+	"os"
+	"sync"
+)
+
+/*** ICMP Identifiers ***/
+
+//Identifiers allocates the ICMP echo identifiers of the sessions of a process.
+//A session owns its identifiers until it finishes, so the sessions sharing a socket do not receive each other replies
+type Identifiers struct {
+	mu   sync.Mutex
+	used map[int]bool
+	next int
+}
+
+//NewIdentifiers returns an allocator that hands out the identifiers from first, wrapping at 65536
+func NewIdentifiers(first int) *Identifiers {
+	return &Identifiers{used: make(map[int]bool), next: first & 0xffff}
+}
+
+//DefaultIdentifiers is the allocator used by the sessions. It starts at the pid of the process, as ping does
+var DefaultIdentifiers = NewIdentifiers(os.Getpid())
+
+//Acquire returns an identifier not owned by another session. Returns ErrIdentifiersExhausted when every identifier is owned
+func (a *Identifiers) Acquire() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := 0; i < 65536; i++ {
+		id := a.next
+		a.next = (a.next + 1) & 0xffff
+		if !a.used[id] {
+			a.used[id] = true
+			return id, nil
+		}
+	}
+	return 0, ErrIdentifiersExhausted
+}
+
+//Release gives back an identifier acquired before
+func (a *Identifiers) Release(id int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.used, id&0xffff)
+}
+
+//acquire takes n identifiers. Nothing is taken when they are not available
+func (a *Identifiers) acquire(n int) ([]int, error) {
+	ids := make([]int, 0, n)
+	for len(ids) < n {
+		id, err := a.Acquire()
+		if err != nil {
+			a.release(ids)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//release gives back the identifiers of a session
+func (a *Identifiers) release(ids []int) {
+	for _, id := range ids {
+		a.Release(id)
+	}
+}
+
+//shard returns the identifier of the shard of host, so every ping to a host uses the same identifier
+func shard(host string, ids []int) int {
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	return ids[h.Sum32()%uint32(len(ids))]
+}
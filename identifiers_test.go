@@ -0,0 +1,54 @@
+package goping
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestIdentifiers(t *testing.T) {
+	a := NewIdentifiers(65534)
+	for _, expected := range []int{65534, 65535, 0} {
+		if id, err := a.Acquire(); err != nil || id != expected {
+			t.Errorf("No match identifier. Expected: [%v <nil>], Got: [%v %v]", expected, id, err)
+		}
+	}
+	//Identifiers are not given twice until they are released
+	for i := 3; i < 65536; i++ {
+		if _, err := a.Acquire(); err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+	}
+	if _, err := a.Acquire(); err != ErrIdentifiersExhausted {
+		t.Errorf("Error Expected: %v Got: %v", ErrIdentifiersExhausted, err)
+	}
+	a.Release(65535)
+	if id, err := a.Acquire(); err != nil || id != 65535 {
+		t.Errorf("Expected the released identifier. Got: [%v %v]", id, err)
+	}
+	//Nothing is taken when there are not enough identifiers
+	a.Release(7)
+	if _, err := a.acquire(2); err != ErrIdentifiersExhausted {
+		t.Errorf("Error Expected: %v Got: %v", ErrIdentifiersExhausted, err)
+	}
+	if ids, err := a.acquire(1); err != nil || ids[0] != 7 {
+		t.Errorf("Expected the released identifier. Got: [%v %v]", ids, err)
+	}
+}
+
+func TestShard(t *testing.T) {
+	ids := []int{10, 20, 30}
+	used := make(map[int]int)
+	for i := 0; i < 300; i++ {
+		host := "10.0.0." + strconv.Itoa(i)
+		id := shard(host, ids)
+		if id != shard(host, ids) {
+			t.Errorf("The shard of %v should not change", host)
+		}
+		used[id]++
+	}
+	for _, id := range ids {
+		if used[id] < 50 {
+			t.Errorf("Hosts not spread over the identifiers: %v", used)
+		}
+	}
+}
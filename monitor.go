@@ -0,0 +1,179 @@
+package goping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+/*** Path Monitoring ***/
+
+//MonitorEventKind tells what happened in a MonitorEvent
+type MonitorEventKind int
+
+const (
+	//HopUpdated is sent after each probe of a hop is answered or lost. The Hop of the event has the updated statistics
+	HopUpdated MonitorEventKind = iota
+	//PathChanged is sent when the address that answers at a hop changes. The Previous address of the event answered before
+	PathChanged
+)
+
+func (k MonitorEventKind) String() string {
+	switch k {
+	case HopUpdated:
+		return "hop updated"
+	case PathChanged:
+		return "path changed"
+	default:
+		return "unknown"
+	}
+}
+
+//MonitorEvent is sent by Monitor for each answer or change found in the path to a host
+type MonitorEvent struct {
+	Kind     MonitorEventKind
+	Time     time.Time
+	Host     string
+	Hop      Hop    //The hop of the event with its statistics since the monitoring started
+	Peer     net.IP //The address that answered the probe. nil when the probe was lost
+	Previous net.IP //The address that answered at the hop before a PathChanged event
+	Len      int    //Number of hops of the path known when the event was sent. Hops with greater TTLs are not probed anymore
+}
+
+//monitorTarget is the path being monitored to a host
+type monitorTarget struct {
+	host    string
+	hops    []*hopState //Indexed by TTL - FirstTTL
+	current []net.IP    //The address that answered last at each hop
+	end     int         //Index of the hop that ends the path
+}
+
+//Monitor probes continuously every hop of the path to each host, as mtr does, and sends the results to the returned channel.
+//Each hop is a request of g with the TTL of the hop, so probes are scheduled by the session with the Interval and Timeout of the Config of g.
+//The hops after the destination are not probed, and are probed again if the destination stops answering at its hop.
+//With cfg.Probes greater than 0 each hop receives Probes probes, otherwise the hops are probed until ctx is cancelled.
+//The channel is closed when every hop was probed or ctx is cancelled. No more events are sent once ctx is done, so the caller
+//may stop reading then; the responses still coming from the session are discarded until it finishes.
+//Without cfg.FlowID load balancers may change the address of a hop between probes, which is reported as a path change
+func Monitor(ctx context.Context, g GoPinger, hosts []string, cfg TraceConfig) (<-chan MonitorEvent, error) {
+	count := cfg.Probes
+	if count < 1 {
+		count = -1
+	}
+	cfg = cfg.withDefaults()
+	if cfg.FirstTTL > cfg.MaxTTL {
+		return nil, fmt.Errorf("FirstTTL %v is greater than MaxTTL %v", cfg.FirstTTL, cfg.MaxTTL)
+	}
+	ping, pong, err := g.StartContext(ctx, cfg.SmoothInterval)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan MonitorEvent)
+	//Sends an event, unless ctx is done and the caller may not read it anymore
+	send := func(e MonitorEvent) {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		defer close(events)
+		//The target and the hop index of each running request
+		type hopRef struct {
+			t *monitorTarget
+			i int
+		}
+		byID := make(map[uint64]hopRef)
+		//Number of requests not finished yet
+		running := 0
+		//No more requests are sent after the ping channel is closed
+		closed := false
+		stop := func() {
+			if !closed {
+				close(ping)
+				closed = true
+			}
+		}
+		defer stop()
+		//Starts a request that probes the hop i of t
+		probe := func(t *monitorTarget, i int) {
+			req := g.NewRequest(t.host, nil)
+			req.Config.TTL, req.Config.Count, req.Config.FlowID = cfg.FirstTTL+i, count, cfg.FlowID
+			t.hops[i] = &hopState{id: req.ID, Hop: Hop{TTL: req.Config.TTL}, runningStats: *newRunningStats()}
+			t.current[i] = nil
+			byID[req.ID] = hopRef{t: t, i: i}
+			running++
+			ping <- req
+		}
+		for _, host := range hosts {
+			n := cfg.MaxTTL - cfg.FirstTTL + 1
+			t := &monitorTarget{host: host, hops: make([]*hopState, n), current: make([]net.IP, n), end: n - 1}
+			for i := range t.hops {
+				probe(t, i)
+			}
+		}
+		if running == 0 {
+			stop()
+		}
+
+		for r := range pong {
+			ref, ok := byID[r.Request.ID]
+			if !ok {
+				continue
+			}
+			//Duplicate and late responses are extra responses of a ping already responded
+			extra := r.Duplicate || r.Late
+			done := !extra && (r.Err == ErrCancelled || (r.Request.Config.Count >= 0 && int(r.Request.Sent) >= r.Request.Config.Count))
+			if done {
+				delete(byID, r.Request.ID)
+				if running--; running == 0 {
+					stop()
+				}
+			}
+			t, i := ref.t, ref.i
+			//Responses of hops that are not probed anymore are discarded
+			if r.Err == ErrCancelled || i > t.end || t.hops[i].id != r.Request.ID {
+				continue
+			}
+			h := t.hops[i]
+			h.add(r)
+			now := time.Now()
+			if extra {
+				send(MonitorEvent{Kind: HopUpdated, Time: now, Host: t.host, Hop: h.snapshot(t.host, false), Peer: r.Peer, Len: t.end + 1})
+				continue
+			}
+
+			//Verifies if the path is shorter or longer than it was
+			switch {
+			case endsPath(r) && i < t.end:
+				//Stops probing the hops after the end of the path
+				for j := i + 1; j <= t.end; j++ {
+					g.Cancel(t.hops[j].id)
+				}
+				t.end = i
+			case answered(r) && !endsPath(r) && i == t.end && t.end < len(t.hops)-1 && !closed:
+				//The end of the path is a router now. Probes the hops after it again
+				h.Reached, h.Err = false, nil
+				for j := i + 1; j < len(t.hops); j++ {
+					probe(t, j)
+				}
+				t.end = len(t.hops) - 1
+			}
+			e := MonitorEvent{Time: now, Host: t.host, Hop: h.snapshot(t.host, done), Len: t.end + 1}
+			//Verifies if another address answers at the hop
+			if answered(r) {
+				e.Peer = r.Peer
+				if prev := t.current[i]; prev != nil && !prev.Equal(r.Peer) {
+					e.Kind, e.Previous = PathChanged, prev
+					send(e)
+				}
+				t.current[i] = r.Peer
+			}
+			e.Kind, e.Previous = HopUpdated, nil
+			send(e)
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,124 @@
+package goping
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	routers := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}
+	alt := net.IPv4(10, 0, 1, 2)
+	p := &pathPinger{routers: routers, dst: net.IPv4(10, 0, 0, 4), alt: map[int]net.IP{2: alt}}
+	events, err := Monitor(context.Background(), New(cfg, p, nil, nil), []string{"dst"}, TraceConfig{MaxTTL: 8, Probes: 4})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+
+	//The last update of each hop and the path changes
+	hops := make(map[int]MonitorEvent)
+	var changes []MonitorEvent
+	for e := range events {
+		switch e.Kind {
+		case HopUpdated:
+			hops[e.Hop.TTL] = e
+		case PathChanged:
+			changes = append(changes, e)
+		}
+	}
+	for ttl := 1; ttl <= 4; ttl++ {
+		e, ok := hops[ttl]
+		if !ok {
+			t.Errorf("No update for hop %v", ttl)
+			continue
+		}
+		if e.Host != "dst" || e.Hop.Stats.Sent != 4 || e.Hop.Stats.Received != 4 || !e.Hop.Stats.Done {
+			t.Errorf("No match stats of hop %v. Got: Host=%v Sent=%v Received=%v Done=%v", ttl, e.Host, e.Hop.Stats.Sent, e.Hop.Stats.Received, e.Hop.Stats.Done)
+		}
+		if e.Hop.Last != float64(ttl) {
+			t.Errorf("No match Last of hop %v. Expected: [%v], Got: [%v]", ttl, ttl, e.Hop.Last)
+		}
+	}
+	if e := hops[4]; !e.Hop.Reached || e.Len != 4 {
+		t.Errorf("Expected the destination at hop 4. Got: Reached=%v Len=%v", e.Hop.Reached, e.Len)
+	}
+
+	//The hop 2 alternates between two addresses
+	if len(changes) != 3 {
+		t.Fatalf("No match path changes. Expected: [%v], Got: [%v]", 3, len(changes))
+	}
+	for i, e := range changes {
+		prev := routers[1]
+		if i%2 == 1 {
+			prev = alt
+		}
+		if e.Hop.TTL != 2 || !e.Previous.Equal(prev) || e.Peer.Equal(prev) {
+			t.Errorf("No match path change %v. Expected: [2 %v], Got: [%v %v %v]", i, prev, e.Hop.TTL, e.Previous, e.Peer)
+		}
+	}
+}
+
+func TestMonitorCancel(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	p := &pathPinger{routers: []net.IP{net.IPv4(10, 0, 0, 1)}, dst: net.IPv4(10, 0, 0, 2)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := Monitor(ctx, New(cfg, p, nil, nil), []string{"a", "b"}, TraceConfig{MaxTTL: 4})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	//Continuous monitoring of both hosts until the context is cancelled
+	updates := make(map[string]int)
+	for e := range events {
+		if updates[e.Host]++; updates["a"] >= 20 && updates["b"] >= 20 {
+			cancel()
+		}
+	}
+	if updates["a"] < 20 || updates["b"] < 20 {
+		t.Errorf("Expected updates of both hosts. Got: %v", updates)
+	}
+}
+
+//pidPinger records the first identifier of the session
+type pidPinger struct {
+	Pinger
+	pid int
+}
+
+func (s *pidPinger) Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, donepong <-chan struct{}, err error) {
+	s.pid = pid
+	return s.Pinger.Start(pid)
+}
+
+func TestMonitorCancelNotRead(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	p := &pidPinger{Pinger: &pathPinger{routers: []net.IP{net.IPv4(10, 0, 0, 1)}, dst: net.IPv4(10, 0, 0, 2)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := Monitor(ctx, New(cfg, p, nil, nil), []string{"a", "b"}, TraceConfig{MaxTTL: 4})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	//The caller stops reading the events once it cancels
+	for i := 0; i < 10; i++ {
+		<-events
+	}
+	//The next event is waiting to be read when the context is cancelled
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	//The session finishes and gives back its identifier
+	owned := func() bool {
+		DefaultIdentifiers.mu.Lock()
+		defer DefaultIdentifiers.mu.Unlock()
+		return DefaultIdentifiers.used[p.pid]
+	}
+	for deadline := time.Now().Add(2 * time.Second); owned(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("The session did not finish after the context was cancelled")
+		}
+	}
+	if _, open := <-events; open {
+		t.Errorf("No event expected after the session finished")
+	}
+}
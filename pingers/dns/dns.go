@@ -0,0 +1,266 @@
+//Package dns implements a goping.Pinger where each ping is a DNS query to the server in Request.Host.
+//It measures the response time of resolvers and reports the failures of the queries as distinct errors
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/sockopt"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+//DefaultPort is the port used when Request.Host does not have the form host:port
+const DefaultPort = "53"
+
+//Names of the phases reported in RawResponse.Phases
+const (
+	PhaseUDP = "udp" //The query over UDP
+	PhaseTCP = "tcp" //The query over TCP after a truncated UDP response
+)
+
+/*** Errors ***/
+var (
+	ErrNXDomain  = errors.New("Non-Existent Domain")
+	ErrServFail  = errors.New("Server Failure")
+	ErrRefused   = errors.New("Query Refused")
+	ErrTruncated = errors.New("Truncated Response")
+	ErrMismatch  = errors.New("Response does not match the query")
+)
+
+//RCodeError is returned when the server answers with a response code without a specific error
+type RCodeError struct {
+	RCode dnsmessage.RCode
+}
+
+func (e *RCodeError) Error() string {
+	return fmt.Sprintf("DNS response code %v", e.RCode)
+}
+
+//Option configures the Pinger returned by New
+type Option func(*pinger)
+
+//Query sets the name and type queried. The default is the NS record of the root zone
+func Query(name string, qtype dnsmessage.Type) Option {
+	return func(p *pinger) {
+		p.qname, p.qtype = name, qtype
+	}
+}
+
+//NoTCPFallback reports truncated UDP responses as ErrTruncated instead of repeating the query over TCP
+func NoTCPFallback() Option {
+	return func(p *pinger) {
+		p.noTCP = true
+	}
+}
+
+//New returns a new Pinger that sends queries to the server in Request.Host, which may have the form host:port
+func New(opts ...Option) goping.Pinger {
+	p := &pinger{qname: ".", qtype: dnsmessage.TypeNS}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+//Pinger is the type the implements goping.Pinger interface
+type pinger struct {
+	qname string
+	qtype dnsmessage.Type
+	noTCP bool
+}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+	name, err := dnsmessage.NewName(dnsName(p.qname))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("Invalid query name %v: %v", p.qname, err)
+	}
+	q := dnsmessage.Question{Name: name, Type: p.qtype, Class: dnsmessage.ClassINET}
+
+	//Initialize the channels used in the select stage
+	input, output, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{})
+	go p.ping(q, input, output, doneOutput)
+	ping, pong, done = input, output, doneOutput
+	return
+}
+
+//dnsName returns name as a fully qualified domain name
+func dnsName(name string) string {
+	if len(name) == 0 || name[len(name)-1] != '.' {
+		return name + "."
+	}
+	return name
+}
+
+//ping sends the queries in their own goroutines. Closes done after every query is finished
+func (p pinger) ping(q dnsmessage.Question, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var wg sync.WaitGroup
+	var address = make(map[string]*net.UDPAddr)
+	var addressError = make(map[string]error)
+
+	for r := range in {
+		//Resolve HostName
+		if _, ok := address[r.Req.Host]; !ok {
+			address[r.Req.Host], addressError[r.Req.Host] = resolve(r.Req.Host)
+		}
+		if addressError[r.Req.Host] != nil {
+			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+			continue
+		}
+		wg.Add(1)
+		go func(r goping.SeqRequest, addr *net.UDPAddr) {
+			defer wg.Done()
+			if resp, ok := p.query(r, q, addr); ok {
+				out <- resp
+			}
+		}(r, address[r.Req.Host])
+	}
+	wg.Wait()
+	close(done)
+}
+
+//resolve finds the address of the server, which may have the form host:port
+func resolve(host string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, DefaultPort)
+	}
+	return net.ResolveUDPAddr("udp", host)
+}
+
+//query sends the question to the server over UDP, and over TCP if the UDP response is truncated.
+//Returns false when the query timed out, so goping reports the timeout
+func (p pinger) query(r goping.SeqRequest, q dnsmessage.Question, addr *net.UDPAddr) (goping.RawResponse, bool) {
+	resp := goping.RawResponse{Seq: r.Seq, Peer: addr.IP, RTT: math.NaN(), Phases: make(map[string]float64)}
+	server := addr.String()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(r.Seq), RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		resp.Err = err
+		return resp, true
+	}
+	deadline := time.Now().Add(r.Req.Config.Timeout)
+	d := net.Dialer{Deadline: deadline, Control: sockopt.Control(r.Req.Config.TTL, r.Req.Config.TOS)}
+
+	start := time.Now()
+	h, err := exchange(d, "udp", server, b, msg.Header.ID, q)
+	resp.Phases[PhaseUDP] = float64(time.Since(start).Nanoseconds()) / 1e6
+	if err == nil && h.Truncated && !p.noTCP {
+		tcpStart := time.Now()
+		h, err = exchange(d, "tcp", server, b, msg.Header.ID, q)
+		resp.Phases[PhaseTCP] = float64(time.Since(tcpStart).Nanoseconds()) / 1e6
+	}
+	rtt := float64(time.Since(start).Nanoseconds()) / 1e6
+
+	var nerr net.Error
+	switch {
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return resp, false
+	case err != nil:
+		resp.Err = err
+	case h.Truncated:
+		resp.Err = ErrTruncated
+	case h.RCode == dnsmessage.RCodeSuccess:
+		resp.RTT = rtt
+	case h.RCode == dnsmessage.RCodeNameError:
+		resp.Err = ErrNXDomain
+	case h.RCode == dnsmessage.RCodeServerFailure:
+		resp.Err = ErrServFail
+	case h.RCode == dnsmessage.RCodeRefused:
+		resp.Err = ErrRefused
+	default:
+		resp.Err = &RCodeError{RCode: h.RCode}
+	}
+	return resp, true
+}
+
+//exchange sends the query b to the server and returns the header of the response.
+//Over UDP, responses that do not match the id and the question are ignored until the deadline
+func exchange(d net.Dialer, network, server string, b []byte, id uint16, q dnsmessage.Question) (dnsmessage.Header, error) {
+	conn, err := d.Dial(network, server)
+	if err != nil {
+		return dnsmessage.Header{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(d.Deadline)
+
+	if network == "tcp" {
+		//Messages over TCP are prefixed with their length
+		l := make([]byte, 2, 2+len(b))
+		binary.BigEndian.PutUint16(l, uint16(len(b)))
+		if _, err := conn.Write(append(l, b...)); err != nil {
+			return dnsmessage.Header{}, err
+		}
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return dnsmessage.Header{}, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(l))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return dnsmessage.Header{}, err
+		}
+		h, ok := matches(buf, id, q)
+		if !ok {
+			return h, ErrMismatch
+		}
+		return h, nil
+	}
+
+	if _, err := conn.Write(b); err != nil {
+		return dnsmessage.Header{}, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return dnsmessage.Header{}, err
+		}
+		if h, ok := matches(buf[:n], id, q); ok {
+			return h, nil
+		}
+	}
+}
+
+//matches parses the header of the response b and verifies that it answers the query id with the question q
+func matches(b []byte, id uint16, q dnsmessage.Question) (dnsmessage.Header, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(b)
+	if err != nil || !h.Response || h.ID != id {
+		return h, false
+	}
+	rq, err := p.Question()
+	if err != nil {
+		//Some servers do not repeat the question in error responses
+		return h, err == dnsmessage.ErrSectionDone && h.RCode != dnsmessage.RCodeSuccess
+	}
+	return h, rq.Type == q.Type && rq.Class == q.Class && equalFold(rq.Name.String(), q.Name.String())
+}
+
+//equalFold compares DNS names ignoring the ASCII case
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
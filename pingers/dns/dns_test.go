@@ -0,0 +1,179 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+//server is a DNS server on loopback, listening on the same UDP and TCP port
+type server struct {
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+func newServer(t *testing.T) *server {
+	var s server
+	var err error
+	if s.tcp, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if s.udp, err = net.ListenPacket("udp", s.tcp.Addr().String()); err != nil {
+		s.tcp.Close()
+		t.Fatalf("Error not expected: %v", err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := s.udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if b := answer(buf[:n], false); b != nil {
+				s.udp.WriteTo(b, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := s.tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				l := make([]byte, 2)
+				if _, err := io.ReadFull(conn, l); err != nil {
+					return
+				}
+				buf := make([]byte, binary.BigEndian.Uint16(l))
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				if b := answer(buf, true); b != nil {
+					binary.BigEndian.PutUint16(l, uint16(len(b)))
+					conn.Write(append(l, b...))
+				}
+			}(conn)
+		}
+	}()
+	return &s
+}
+
+func (s *server) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+//answer builds the response to the query b. The name of the question selects the response:
+//nx.test, fail.test and refused.test answer with the error, big.test is truncated over UDP, slow.test is not answered
+func answer(b []byte, tcp bool) []byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil || len(m.Questions) != 1 {
+		return nil
+	}
+	m.Header.Response = true
+	switch m.Questions[0].Name.String() {
+	case "nx.test.":
+		m.Header.RCode = dnsmessage.RCodeNameError
+	case "fail.test.":
+		m.Header.RCode = dnsmessage.RCodeServerFailure
+	case "refused.test.":
+		m.Header.RCode = dnsmessage.RCodeRefused
+	case "slow.test.":
+		return nil
+	case "big.test.":
+		if !tcp {
+			m.Header.Truncated = true
+			break
+		}
+		fallthrough
+	default:
+		m.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: m.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+		}}
+	}
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+//ping sends count queries to the server with the pinger p and returns the responses
+func ping(t *testing.T, p goping.Pinger, host string, count int) []goping.Response {
+	cfg := goping.Config{Count: count, Interval: 10 * time.Millisecond, Timeout: 200 * time.Millisecond}
+	g := goping.New(cfg, p, nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	ping <- g.NewRequest(host, nil)
+	close(ping)
+	var responses []goping.Response
+	for r := range pong {
+		responses = append(responses, r)
+	}
+	if len(responses) != count {
+		t.Errorf("No match responses. Expected: [%v], Got: [%v]", count, len(responses))
+	}
+	return responses
+}
+
+func TestPinger(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+	host := s.udp.LocalAddr().String()
+
+	tt := []struct {
+		name   string
+		opts   []Option
+		err    error
+		phases []string
+	}{
+		{name: "ok.test", phases: []string{PhaseUDP}},
+		{name: "big.test", phases: []string{PhaseUDP, PhaseTCP}},
+		{name: "big.test", opts: []Option{NoTCPFallback()}, err: ErrTruncated},
+		{name: "nx.test", err: ErrNXDomain},
+		{name: "fail.test", err: ErrServFail},
+		{name: "refused.test", err: ErrRefused},
+		{name: "slow.test", err: goping.ErrTimeout},
+	}
+	for _, tc := range tt {
+		p := New(append(tc.opts, Query(tc.name, dnsmessage.TypeA))...)
+		for _, r := range ping(t, p, host, 2) {
+			if !errors.Is(r.Err, tc.err) {
+				t.Errorf("No match error for %v. Expected: [%v], Got: [%v]", tc.name, tc.err, r.Err)
+			}
+			if tc.err == nil && (math.IsNaN(r.RTT) || r.RTT < 0) {
+				t.Errorf("Invalid RTT for %v: %v", tc.name, r.RTT)
+			}
+			if tc.err != nil && !math.IsNaN(r.RTT) {
+				t.Errorf("RTT not expected for %v: %v", tc.name, r.RTT)
+			}
+			for _, phase := range tc.phases {
+				if _, ok := r.Phases[phase]; !ok {
+					t.Errorf("Phase %v not found for %v: %v", phase, tc.name, r.Phases)
+				}
+			}
+		}
+	}
+}
+
+func TestResolveDefaultPort(t *testing.T) {
+	addr, err := resolve("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if addr.Port != 53 {
+		t.Errorf("No match port. Expected: [%v], Got: [%v]", 53, addr.Port)
+	}
+}
@@ -0,0 +1,184 @@
+//Package http implements a goping.Pinger where Request.Host is a URL and each ping is an HTTP request.
+//The time of each step of the request is reported in RawResponse.Phases
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	nethttp "net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/sockopt"
+)
+
+//Names of the phases reported in RawResponse.Phases
+const (
+	PhaseDNS     = "dns"     //Name resolution
+	PhaseConnect = "connect" //TCP handshake
+	PhaseTLS     = "tls"     //TLS handshake
+	PhaseTTFB    = "ttfb"    //From the start of the ping to the first byte of the response
+	PhaseTotal   = "total"   //From the start of the ping to the end of the response body
+)
+
+//maxBody is the maximum number of bytes of the response body that are read
+const maxBody = 1 << 20
+
+//StatusError is returned when the server answers with a status code other than 2xx
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unexpected HTTP status: %v", e.Status)
+}
+
+//TLSError is returned when the TLS handshake fails
+type TLSError struct {
+	Err error
+}
+
+func (e *TLSError) Error() string {
+	return fmt.Sprintf("TLS handshake failed: %v", e.Err)
+}
+
+//Unwrap returns the handshake error
+func (e *TLSError) Unwrap() error {
+	return e.Err
+}
+
+//New returns a new Pinger that sends a GET request to the URL in Request.Host.
+//URLs without scheme use http. Each ping opens a new connection, so every phase is measured
+func New() goping.Pinger {
+	return &pinger{}
+}
+
+//Pinger is the type the implements goping.Pinger interface
+type pinger struct{}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+	//Initialize the channels used in the select stage
+	input, output, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{})
+	go p.ping(input, output, doneOutput)
+	ping, pong, done = input, output, doneOutput
+	return
+}
+
+//ping sends the requests in their own goroutines. Closes done after every request is finished
+func (p pinger) ping(in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var wg sync.WaitGroup
+	for r := range in {
+		wg.Add(1)
+		go func(r goping.SeqRequest) {
+			defer wg.Done()
+			if resp, ok := get(r); ok {
+				out <- resp
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(done)
+}
+
+//get sends the request and builds the response.
+//Returns false when the request timed out, so goping reports the timeout
+func get(r goping.SeqRequest) (goping.RawResponse, bool) {
+	resp := goping.RawResponse{Seq: r.Seq, RTT: math.NaN()}
+	url := r.Req.Host
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.Req.Config.Timeout)
+	defer cancel()
+
+	//Timestamps and results of the phases, filled by the trace.
+	//The trace functions may be called from the transport goroutines, even after the request is finished
+	var mu sync.Mutex
+	var start, dnsStart, connStart, tlsStart time.Time
+	var tlsErr error
+	var peer net.IP
+	phases := make(map[string]float64)
+	ms := func(from time.Time) float64 { return float64(time.Since(from).Nanoseconds()) / 1e6 }
+	locked := func(f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		f()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { locked(func() { dnsStart = time.Now() }) },
+		DNSDone:      func(httptrace.DNSDoneInfo) { locked(func() { phases[PhaseDNS] = ms(dnsStart) }) },
+		ConnectStart: func(string, string) { locked(func() { connStart = time.Now() }) },
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				locked(func() { phases[PhaseConnect] = ms(connStart) })
+			}
+		},
+		TLSHandshakeStart: func() { locked(func() { tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			locked(func() { phases[PhaseTLS], tlsErr = ms(tlsStart), err })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if addr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				locked(func() { peer = addr.IP })
+			}
+		},
+		GotFirstResponseByte: func() { locked(func() { phases[PhaseTTFB] = ms(start) }) },
+	}
+	req, err := nethttp.NewRequest(nethttp.MethodGet, url, nil)
+	if err != nil {
+		resp.Err = err
+		return resp, true
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	//A new transport for each ping, so connections are not reused and the TTL and TOS of the request are applied
+	dialer := &net.Dialer{Control: sockopt.Control(r.Req.Config.TTL, r.Req.Config.TOS)}
+	client := &nethttp.Client{
+		Transport: &nethttp.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true},
+		//Redirects are answers of the server. They are not followed
+		CheckRedirect: func(*nethttp.Request, []*nethttp.Request) error { return nethttp.ErrUseLastResponse },
+	}
+	locked(func() { start = time.Now() })
+	hresp, err := client.Do(req)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, io.LimitReader(hresp.Body, maxBody))
+		hresp.Body.Close()
+	}
+	mu.Lock()
+	total, handshakeErr := ms(start), tlsErr
+	resp.Peer, resp.Phases = peer, make(map[string]float64, len(phases)+1)
+	for k, v := range phases {
+		resp.Phases[k] = v
+	}
+	mu.Unlock()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return resp, false
+	case handshakeErr != nil:
+		resp.Err = &TLSError{Err: handshakeErr}
+	case err != nil:
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return resp, false
+		}
+		resp.Err = err
+	case hresp.StatusCode < 200 || hresp.StatusCode > 299:
+		resp.Phases[PhaseTotal] = total
+		resp.Err = &StatusError{StatusCode: hresp.StatusCode, Status: hresp.Status}
+	default:
+		resp.Phases[PhaseTotal] = total
+		resp.RTT = total
+	}
+	return resp, true
+}
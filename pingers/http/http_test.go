@@ -0,0 +1,80 @@
+package http
+
+import (
+	"errors"
+	"math"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+func TestPinger(t *testing.T) {
+	handler := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(nethttp.StatusInternalServerError)
+		}
+		w.Write([]byte("pong"))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	//The certificate of the test server is not trusted by the pinger
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	cfg := goping.Config{Count: 2, Interval: 10 * time.Millisecond, Timeout: 5 * time.Second}
+	g := goping.New(cfg, New(), nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	ok := g.NewRequest(plain.URL+"/", nil)
+	fail := g.NewRequest(plain.URL+"/fail", nil)
+	untrusted := g.NewRequest(secure.URL+"/", nil)
+	go func() {
+		for _, r := range []goping.Request{ok, fail, untrusted} {
+			ping <- r
+		}
+		close(ping)
+	}()
+
+	var received int
+	for r := range pong {
+		received++
+		switch r.Request.ID {
+		case ok.ID:
+			if r.Err != nil {
+				t.Errorf("Error not expected: %v", r.Err)
+			}
+			if math.IsNaN(r.RTT) || r.RTT != r.Phases[PhaseTotal] {
+				t.Errorf("RTT should be the total time. RTT: %v Phases: %v", r.RTT, r.Phases)
+			}
+			for _, phase := range []string{PhaseConnect, PhaseTTFB, PhaseTotal} {
+				if _, found := r.Phases[phase]; !found {
+					t.Errorf("Phase %v not found in %v", phase, r.Phases)
+				}
+			}
+			if r.Peer == nil {
+				t.Errorf("Peer should be the server address")
+			}
+		case fail.ID:
+			var serr *StatusError
+			if !errors.As(r.Err, &serr) || serr.StatusCode != nethttp.StatusInternalServerError {
+				t.Errorf("Error Expected: StatusError 500 Got: %v", r.Err)
+			}
+		case untrusted.ID:
+			var terr *TLSError
+			if !errors.As(r.Err, &terr) {
+				t.Errorf("Error Expected: TLSError Got: %v", r.Err)
+			}
+			if _, found := r.Phases[PhaseTLS]; !found {
+				t.Errorf("Phase %v not found in %v", PhaseTLS, r.Phases)
+			}
+		}
+	}
+	if received != 3*cfg.Count {
+		t.Errorf("No match responses. Expected: [%v], Got: [%v]", 3*cfg.Count, received)
+	}
+}
@@ -0,0 +1,142 @@
+package icmpv4
+
+import (
+	"sync"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/netns"
+	"golang.org/x/net/bpf"
+)
+
+//demux shares the raw sockets of a pinger between its sessions. Each reply is sent to the session that owns its identifier.
+//The kernel filter of each socket is rebuilt whenever the owned identifiers change, so only our replies wake up the receivers.
+//Every raw socket receives a copy of each ICMP packet. With several shards, the filter of each socket also selects the
+//replies of the destinations of its shard, so each reply is received once. Each network namespace the sessions send from has its own sockets
+type demux struct {
+	mu       sync.Mutex
+	nets     map[string][]conn //The socket of each shard, by the path of its network namespace
+	shards   int
+	sys      syscallWrapperInterface
+	sessions int                               //Number of sessions using the sockets
+	owners   map[int]chan<- goping.RawResponse //The response channel of the session that owns each identifier
+	stop     chan struct{}                     //Closed to stop the receivers of the sockets
+}
+
+//conn is the socket of a shard, with the send times of its requests
+type conn struct {
+	fd   int
+	sent *stamps
+}
+
+func newDemux() *demux {
+	return &demux{owners: make(map[int]chan<- goping.RawResponse)}
+}
+
+//join returns the shared sockets of the network namespace of the pinger, one per shard.
+//The first session opens them and starts a receiver for each one
+func (m *demux) join(p pinger) ([]conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == 0 {
+		m.nets, m.shards, m.sys, m.stop = make(map[string][]conn), p.shards, p.syscall, make(chan struct{})
+		if _, err := m.open(p, netns.Path(p.namespace)); err != nil {
+			return nil, err
+		}
+	}
+	m.sessions++
+	return m.nets[netns.Path(p.namespace)], nil
+}
+
+//enter returns the shared sockets of the network namespace ns, one per shard. The first session that sends from the namespace opens them
+//and starts a receiver for each one. They are closed with the sockets of the pinger, after the last session leaves
+func (m *demux) enter(p pinger, ns string) ([]conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if conns, ok := m.nets[ns]; ok {
+		return conns, nil
+	}
+	return m.open(p, ns)
+}
+
+//open opens the sockets of the network namespace ns, one per shard, with the filter of the owned identifiers, and starts their receivers.
+//It must be called with the lock held
+func (m *demux) open(p pinger, ns string) ([]conn, error) {
+	var conns []conn
+	for k := 0; k < m.shards; k++ {
+		fd, err := p.openConn(ns)
+		if err == nil {
+			conns = append(conns, conn{fd: fd, sent: newStamps()})
+			err = m.filter(fd, k)
+		}
+		if err != nil {
+			for _, c := range conns {
+				p.syscall.Close(c.fd)
+			}
+			return nil, err
+		}
+	}
+	for k, c := range conns {
+		go p.pong(c, k, m.stop)
+	}
+	m.nets[ns] = conns
+	return conns, nil
+}
+
+//own sends the replies to the identifier id to the response channel out
+func (m *demux) own(id int, out chan<- goping.RawResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.owners[id&0xffff]; ok {
+		m.owners[id&0xffff] = out
+		return
+	}
+	m.owners[id&0xffff] = out
+	//The filters must accept the identifier before its first request is sent
+	m.refilter()
+}
+
+//owner returns the response channel of the session that owns the identifier id, or nil when no session owns it
+func (m *demux) owner(id int) chan<- goping.RawResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.owners[id&0xffff]
+}
+
+//leave releases the identifiers of a session. The receivers of the sockets stop after the last session leaves, and close them
+func (m *demux) leave(ids []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		delete(m.owners, id&0xffff)
+	}
+	if m.sessions--; m.sessions == 0 {
+		close(m.stop)
+		return
+	}
+	m.refilter()
+}
+
+//filter attaches to the socket fd of the shard k the filter of the owned identifiers. It must be called with the lock held
+func (m *demux) filter(fd, k int) error {
+	ids := make([]int, 0, len(m.owners))
+	for id := range m.owners {
+		ids = append(ids, id)
+	}
+	prog, err := bpf.Assemble(echoFilter(ids, k, m.shards))
+	if err != nil {
+		return err
+	}
+	return m.sys.AttachFilter(fd, prog)
+}
+
+//refilter rebuilds the filters of the sockets. When one fails its filter is removed, as the old one could drop the replies of
+//a new identifier, and the replies are only verified by its receiver. It must be called with the lock held
+func (m *demux) refilter() {
+	for _, conns := range m.nets {
+		for k, c := range conns {
+			if err := m.filter(c.fd, k); err != nil {
+				m.sys.DetachFilter(c.fd)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package icmpv4
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+
+	"golang.org/x/net/bpf"
+)
+
+//bpfMaxInstructions is the largest classic BPF program accepted by linux
+const bpfMaxInstructions = 4096
+
+//Values returned by the filter. A packet is accepted whole or dropped
+const (
+	filterAccept = 0xffffffff
+	filterReject = 0
+)
+
+//echoFilter builds the classic BPF program of the raw socket of the shard of shards. It only accepts the echo replies, and the ICMP errors
+//quoting an echo request, sent with one of the identifiers ids to a destination of the shard, as parseMessage and shardOf do.
+//Raw IPv4 sockets filter the packets from the IPv4 header.
+//The identifiers are verified by ranges, and not verified when they need more instructions than linux accepts
+func echoFilter(ids []int, shard, shards int) []bpf.Instruction {
+	//The identifier of the echo reply, whose destination is its source
+	reply := append(shardFilter(bpf.LoadAbsolute{Off: replySrc, Size: 4}, shard, shards),
+		bpf.LoadIndirect{Off: 4, Size: 2},
+	)
+	//The identifier of the quoted echo request
+	quoted := append(shardFilter(bpf.LoadIndirect{Off: 8 + 16, Size: 4}, shard, shards),
+		bpf.LoadIndirect{Off: 8 + ipHeaderLen + 4, Size: 2},
+	)
+	prog := []bpf.Instruction{
+		//X is the length of the IPv4 header
+		bpf.LoadMemShift{Off: 0},
+		//The ICMP type
+		bpf.LoadIndirect{Off: 0, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0, SkipTrue: 6},
+		//Our own requests are received when pinging a local address
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 8, SkipTrue: 4},
+		//Only error messages quoting one of our echo requests are accepted
+		bpf.LoadIndirect{Off: 8 + 9, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: 1, SkipTrue: 2},
+		bpf.LoadIndirect{Off: 8 + ipHeaderLen, Size: 1},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: 8, SkipTrue: uint8(len(reply) + 2)},
+		bpf.RetConstant{Val: filterReject},
+	}
+	prog = append(prog, reply...)
+	prog = append(prog, bpf.Jump{Skip: uint32(len(quoted))})
+	prog = append(prog, quoted...)
+	var checks []bpf.Instruction
+	for _, r := range idRanges(ids) {
+		if r[0] == r[1] {
+			checks = append(checks,
+				bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: uint32(r[0]), SkipTrue: 1},
+				bpf.RetConstant{Val: filterAccept},
+			)
+			continue
+		}
+		checks = append(checks,
+			bpf.JumpIf{Cond: bpf.JumpLessThan, Val: uint32(r[0]), SkipTrue: 2},
+			bpf.JumpIf{Cond: bpf.JumpGreaterThan, Val: uint32(r[1]), SkipTrue: 1},
+			bpf.RetConstant{Val: filterAccept},
+		)
+	}
+	if len(prog)+len(checks)+1 > bpfMaxInstructions {
+		//Too many identifiers. Every identifier is accepted and the replies are verified by the receiver
+		return append(prog, bpf.RetConstant{Val: filterAccept})
+	}
+	prog = append(prog, checks...)
+	return append(prog, bpf.RetConstant{Val: filterReject})
+}
+
+//shardFilter returns the instructions that reject the packets whose destination, read by load, is not of the shard of shards.
+//Nothing is verified with a single shard
+func shardFilter(load bpf.Instruction, shard, shards int) []bpf.Instruction {
+	if shards < 2 {
+		return nil
+	}
+	return []bpf.Instruction{
+		load,
+		bpf.ALUOpConstant{Op: bpf.ALUOpMod, Val: uint32(shards)},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(shard), SkipTrue: 1},
+		bpf.RetConstant{Val: filterReject},
+	}
+}
+
+//shardOf returns the shard of shards that sends the requests to the IPv4 destination dst, and receives its replies
+func shardOf(dst net.IP, shards int) int {
+	if shards < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(dst.To4()) % uint32(shards))
+}
+
+//idRanges returns the identifiers as sorted ranges of consecutive values, from the first to the last
+func idRanges(ids []int) [][2]int {
+	sorted := make([]int, len(ids))
+	for i, id := range ids {
+		sorted[i] = id & 0xffff
+	}
+	sort.Ints(sorted)
+	var ranges [][2]int
+	for _, id := range sorted {
+		if n := len(ranges); n > 0 && id <= ranges[n-1][1]+1 {
+			if id > ranges[n-1][1] {
+				ranges[n-1][1] = id
+			}
+			continue
+		}
+		ranges = append(ranges, [2]int{id, id})
+	}
+	return ranges
+}
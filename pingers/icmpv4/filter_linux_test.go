@@ -0,0 +1,142 @@
+package icmpv4
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+//accepted runs the filter of the identifiers ids on the packet buf
+func accepted(t *testing.T, ids []int, buf []byte) bool {
+	vm, err := bpf.NewVM(echoFilter(ids, 0, 1))
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	n, err := vm.Run(buf)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	return n > 0
+}
+
+func TestEchoFilter(t *testing.T) {
+	data := make([]byte, 16)
+	echo := func(id int) []byte {
+		return packet(t, icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: id, Seq: 7, Data: data}})
+	}
+	reply := func(id int) []byte {
+		return packet(t, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: id, Seq: 7, Data: data}})
+	}
+	exceeded := func(quoted []byte) []byte {
+		return packet(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quoted}})
+	}
+	//A reply inside an IPv4 header with options
+	h, err := (&ipv4.Header{Version: 4, Len: ipHeaderLen + 4, TotalLen: ipHeaderLen + 4 + 24, TTL: 64, Protocol: 1, Src: net.IPv4(10, 0, 0, 1), Dst: net.IPv4(10, 0, 0, 2), Options: []byte{1, 1, 1, 1}}).Marshal()
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	options := append(h, reply(0x1234)[ipHeaderLen:]...)
+	//An error quoting a message that is not an echo request
+	other := packet(t, icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: reply(0x1234)}})
+
+	ids := []int{0x1234, 0x2000, 0x2001, 0x2002, 0x3000}
+	tt := []struct {
+		name string
+		ids  []int
+		buf  []byte
+		ok   bool
+	}{
+		{"reply", ids, reply(0x1234), true},
+		{"reply in range", ids, reply(0x2001), true},
+		{"reply last of range", ids, reply(0x2002), true},
+		{"reply not owned", ids, reply(0x2003), false},
+		{"reply below range", ids, reply(0x1fff), false},
+		{"reply with ip options", ids, options, true},
+		{"own request", ids, echo(0x1234), false},
+		{"exceeded", ids, exceeded(echo(0x3000)), true},
+		{"exceeded not owned", ids, exceeded(echo(0x3001)), false},
+		{"exceeded short quote", ids, exceeded(echo(0x1234)[:quotedData-28]), true},
+		{"exceeded truncated", ids, exceeded(echo(0x1234)[:ipHeaderLen+4]), false},
+		{"error not quoting a request", ids, other, false},
+		{"identifier of 16 bits", []int{0x11234}, reply(0x1234), true},
+		{"no identifiers", nil, reply(0x1234), false},
+	}
+	for _, tc := range tt {
+		if ok := accepted(t, tc.ids, tc.buf); ok != tc.ok {
+			t.Errorf("No match accepted for %v. Expected: [%v], Got: [%v]", tc.name, tc.ok, ok)
+		}
+		//The filter must agree with the receiver
+		if id, _, _, _, _, ok := parseMessage(net.IPv4(10, 0, 0, 1), tc.buf); ok && tc.ok && !owned(tc.ids, id) {
+			t.Errorf("Accepted %v with the identifier %#x that is not owned", tc.name, id)
+		}
+	}
+}
+
+func TestEchoFilterSize(t *testing.T) {
+	//Every other identifier is owned, so each one needs its own check
+	var ids []int
+	for id := 0; id < 0x10000; id += 2 {
+		ids = append(ids, id)
+	}
+	for _, n := range []int{1, 100, 1000, len(ids)} {
+		prog, err := bpf.Assemble(echoFilter(ids[:n], 0, 1))
+		if err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		if len(prog) > bpfMaxInstructions {
+			t.Errorf("Too many instructions for %v identifiers. Expected: [<=%v], Got: [%v]", n, bpfMaxInstructions, len(prog))
+		}
+	}
+	//Too many identifiers to verify: every reply is accepted
+	if !accepted(t, ids, packet(t, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 1}})) {
+		t.Errorf("Expected every reply accepted when the identifiers are not verified")
+	}
+}
+
+func TestEchoFilterShards(t *testing.T) {
+	data := make([]byte, 16)
+	ids := []int{0x1234}
+	const shards = 3
+	for a := 1; a < 10; a++ {
+		src := net.IPv4(10, 0, 0, byte(a))
+		reply, err := (&ipv4.Header{Version: 4, Len: ipHeaderLen, TotalLen: ipHeaderLen + 24, TTL: 64, Protocol: 1, Src: src, Dst: net.IPv4(10, 0, 1, 1)}).Marshal()
+		if err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		b, _ := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: 7, Data: data}}).Marshal(nil)
+		reply = append(reply, b...)
+		//packet sends the echo requests to 10.0.0.2. The error quotes the one sent to src
+		echo := packet(t, icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 0x1234, Seq: 7, Data: data}})
+		copy(echo[16:20], src.To4())
+		exceeded := packet(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: echo}})
+		//Each reply and error is accepted by the shard of its destination only
+		for shard := 0; shard < shards; shard++ {
+			for _, buf := range [][]byte{reply, exceeded} {
+				vm, err := bpf.NewVM(echoFilter(ids, shard, shards))
+				if err != nil {
+					t.Fatalf("Error not expected: %v", err)
+				}
+				n, err := vm.Run(buf)
+				if err != nil {
+					t.Fatalf("Error not expected: %v", err)
+				}
+				if ok := shardOf(src, shards) == shard; (n > 0) != ok {
+					t.Errorf("No match accepted for %v in shard %v. Expected: [%v], Got: [%v]", src, shard, ok, n > 0)
+				}
+			}
+		}
+	}
+}
+
+//owned tells if the identifier id is one of ids
+func owned(ids []int, id int) bool {
+	for _, i := range ids {
+		if i&0xffff == id&0xffff {
+			return true
+		}
+	}
+	return false
+}
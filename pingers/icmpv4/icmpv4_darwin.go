@@ -17,13 +17,14 @@ import (
 )
 
 //New returns a new Pinger
-func New() goping.Pinger {
-	return &pinger{syscall: new(syscallWrapper)}
+func New(opts ...Option) goping.Pinger {
+	return &pinger{syscall: new(syscallWrapper), options: newOptions(opts)}
 }
 
 //Pinger is the type the implements goping.Pinger interface
 type pinger struct {
 	syscall syscallWrapperInterface //The syscall Wrapper object
+	options
 }
 
 //Start is the implementation of the method goping.Pinger.Start
@@ -37,10 +38,12 @@ func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan gopin
 		//Returns error that connection could not be opened
 		err = fmt.Errorf("Connection could not be opened: %v", lerr)
 	} else {
+		//The identifiers of the pings sent by the session
+		ids := new(goping.IdentSet)
 		//Start Sending ICMPRequests to the File Descriptor
-		go p.ping(pid, fd, input, output, doneInput)
+		go p.ping(ids, fd, input, output, doneInput)
 		//Start receiving ICMPReplies from the File Descriptor
-		go p.pong(pid, fd, output, doneInput, doneOutput)
+		go p.pong(ids, fd, output, doneInput, doneOutput)
 	}
 	if err != nil {
 		return
@@ -88,7 +91,12 @@ func (p pinger) OpenConn() (int, error) {
 	return fd, nil
 }
 
-func (p pinger) ping(gpid int, fd int, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+//Resolve is the implementation of the method goping.Resolver.Resolve
+func (p pinger) Resolve(host string) (net.IP, error) {
+	return resolve(host)
+}
+
+func (p pinger) ping(ids *goping.IdentSet, fd int, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
 	var echo icmp.Echo
 	var icmpmsg = icmp.Message{
 		Type: ipv4.ICMPTypeEcho,
@@ -101,62 +109,70 @@ func (p pinger) ping(gpid int, fd int, in <-chan goping.SeqRequest, out chan<- g
 	}
 	var ip net.IP
 	var icmpb, ipv4b []byte
-	var buffer bytes.Buffer
 	var tv syscall.Timeval
 
 	var address = make(map[string]net.IP)
 	var addressError = make(map[string]error)
 
 	for r := range in {
-		//Resolve HostName
+		//Resolve HostName, when the session did not
 		var err error
-		if _, ok := address[r.Req.Host]; !ok {
-			if addr, lerr := net.ResolveIPAddr("ip4", r.Req.Host); lerr != nil {
-				addressError[r.Req.Host] = lerr
-				address[r.Req.Host] = net.IPv4(0, 0, 0, 0)
-			} else {
-				address[r.Req.Host] = addr.IP.To4()
+		if ip = r.Dst.To4(); ip == nil {
+			if _, ok := address[r.Req.Host]; !ok {
+				if addr, lerr := p.Resolve(r.Req.Host); lerr != nil {
+					addressError[r.Req.Host] = lerr
+					address[r.Req.Host] = net.IPv4(0, 0, 0, 0)
+				} else {
+					address[r.Req.Host] = addr
+				}
 			}
-		}
-		if addressError[r.Req.Host] != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
-			continue
+			if addressError[r.Req.Host] != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+				continue
+			}
+			ip = address[r.Req.Host]
 		}
 		//Create the target address to use in the SendTo socket method
-		ip = address[r.Req.Host]
 		var to syscall.SockaddrInet4
 		to.Port = 0
 		to.Addr[0], to.Addr[1], to.Addr[2], to.Addr[3] = ip[0], ip[1], ip[2], ip[3]
 
-		//Built the Data to be send
-		buffer.Reset()
+		//Built the Data to be send, with the size of the request
 		syscall.Gettimeofday(&tv)
-		binary.Write(&buffer, binary.LittleEndian, &tv)
-		echo.Data = buffer.Bytes()
-		//Get the ICMP echo Identifier
-		echo.ID = gpid
+		echo.Data = p.payload(r.Req.Config, tv)
+		//Get the ICMP echo Identifier. Its replies are accepted by pong
+		echo.ID = r.ID
+		ids.Add(r.ID)
 		//Get the ICMP echo sequence number
 		echo.Seq = r.Seq
 		//Build the ICMP echo body
 		icmpmsg.Body = &echo
 		//Build bytes of the ICMP echo
 		if icmpb, err = icmpmsg.Marshal(nil); err != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not marshall ICMP Echo"), RTT: math.NaN()}
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not marshall ICMP Echo"), RTT: math.NaN()}
 			continue
 		}
 		//Builds the ip header
 		iph.TOS = int(r.Req.Config.TOS)
 		iph.TotalLen = 20 + len(icmpb) // 20 bytes for IP, len(wb) for ICMP
 		iph.TTL = int(r.Req.Config.TTL)
+		iph.Flags = 0
+		if r.Req.Config.DontFragment {
+			iph.Flags = ipv4.DontFragment
+		}
 		iph.Dst = ip
 		//Pack IP Header
 		if ipv4b, err = iph.Marshal(); err != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not marshall IP Header"), RTT: math.NaN()}
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not marshall IP Header"), RTT: math.NaN()}
 			continue
 		}
 		//Sending the packet through the network
-		if err = p.syscall.Sendto(fd, append(ipv4b, icmpb...), 0, &to); err != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not Send Ping over the socket"), RTT: math.NaN()}
+		if err = p.syscall.Sendto(fd, append(ipv4b, icmpb...), 0, &to); err == syscall.EMSGSIZE {
+			//The kernel does not fragment the packets with the IP header built by us. They must fit the MTU of the interface
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: goping.ErrMessageTooLong, RTT: math.NaN()}
+			continue
+		} else if err != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not Send Ping over the socket"), RTT: math.NaN()}
 			continue
 		}
 	}
@@ -165,9 +181,9 @@ func (p pinger) ping(gpid int, fd int, in <-chan goping.SeqRequest, out chan<- g
 	}()
 }
 
-func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-chan struct{}, done chan<- struct{}) {
+func (p pinger) pong(ids *goping.IdentSet, fd int, out chan<- goping.RawResponse, donein <-chan struct{}, done chan<- struct{}) {
 	//Buffer to receive the ping packet
-	buf := make([]byte, 1024)
+	buf := make([]byte, 65536)
 	//Buffer to receive the control message
 	oob := make([]byte, 64)
 	//Buffer to read oob
@@ -188,7 +204,7 @@ func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-c
 		}
 
 		//Receives a message from the socket sent by the kernel
-		_, oobn, _, from, err := p.syscall.Recvmsg(fd, buf, oob, 0)
+		n, oobn, _, from, err := p.syscall.Recvmsg(fd, buf, oob, 0)
 		if err != nil {
 			//fmt.Printf("Error reading recvmsg %v\n", err)
 			//Error reading packaging
@@ -196,23 +212,32 @@ func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-c
 		}
 		var endTime = time.Now()
 
-		//Finds the pid and seq value.
+		//Finds the pid, seq and destination value.
 		var pid, seq int
+		var dst net.IP
 		switch buf[20] {
 		case 0:
-			//Received an Echo Reply
+			//Received an Echo Reply. Its source is the destination of the request
 			pid = int(uint16(buf[24])<<8 | uint16(buf[25]))
 			seq = int(uint16(buf[26])<<8 | uint16(buf[27]))
+			dst = net.IPv4(buf[12], buf[13], buf[14], buf[15])
 		default:
 			//Received an error message
 			pid = int(uint16(buf[52])<<8 | uint16(buf[53]))
 			seq = int(uint16(buf[54])<<8 | uint16(buf[55]))
+			dst = net.IPv4(buf[44], buf[45], buf[46], buf[47])
 			//emsg = msg[28:] //20+28+4
 		}
-		//Blocks processing if pid ! gpid or if packet is not an EchoRequest.
-		if !(pid == gpid && buf[20] != 8) {
+		//Blocks processing if pid was not sent by the session or if packet is not an EchoRequest.
+		if !(ids.Has(pid) && buf[20] != 8) {
 			continue
 		}
+		//Verifies that the echo data came back as it was sent. The send times are not kept, so the flow word of a FlowID may be there
+		var perr error
+		if buf[20] == 0 && n > 28 && !p.intact(buf[28:n], true) {
+			perr = goping.ErrCorruptPayload
+		}
+		var recvStamp = goping.StampUser
 		//Parses the Control Message to find the SO_TIMESTAMP value
 		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
 		if err != nil {
@@ -230,7 +255,7 @@ func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-c
 				bbuf.Write(m.Data)
 				binary.Read(&bbuf, binary.LittleEndian, &tv)
 				bbuf.Reset()
-				endTime = time.Unix(tv.Unix())
+				endTime, recvStamp = time.Unix(tv.Unix()), goping.StampKernel
 			}
 		}
 		tv.Sec = 0
@@ -249,9 +274,9 @@ func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-c
 			from.(*syscall.SockaddrInet4).Addr[3],
 		)
 		//GoRoutine that sends the raw response to channel out
-		go func(seq int, msg []byte, peer net.IP, rtt float64) {
-			out <- goping.RawResponse{Seq: seq, ICMPMessage: msg, Peer: peer, RTT: rtt}
-		}(seq, buf[:40], peer, float64(endTime.Sub(startTime).Nanoseconds())/1e6)
+		go func(pid, seq int, msg []byte, peer, dst net.IP, rtt float64, stamp goping.StampSource, err error) {
+			out <- goping.RawResponse{Seq: seq, ID: pid, ICMPMessage: msg, Peer: peer, Dst: dst, RTT: rtt, RecvStamp: stamp, Err: err}
+		}(pid, seq, buf[:40], peer, dst, float64(endTime.Sub(startTime).Nanoseconds())/1e6, recvStamp, perr)
 	}
 }
 
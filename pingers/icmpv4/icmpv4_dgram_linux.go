@@ -0,0 +1,453 @@
+package icmpv4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/netns"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+//ErrPingNotPermitted is returned when the group of the process is not allowed to open ping sockets
+var ErrPingNotPermitted = errors.New("Unprivileged ICMP sockets not permitted")
+
+//pingGroupRange is the sysctl that lists the groups allowed to open ping sockets
+const pingGroupRange = "/proc/sys/net/ipv4/ping_group_range"
+
+//Values of sock_extended_err.ee_origin
+const (
+	soEEOriginICMP = 2
+)
+
+//sockExtendedErr is the linux struct sock_extended_err received with IP_RECVERR
+type sockExtendedErr struct {
+	Errno  uint32
+	Origin uint8
+	Type   uint8
+	Code   uint8
+	Pad    uint8
+	Info   uint32
+	Data   uint32
+}
+
+//NewUnprivileged returns a new Pinger that uses unprivileged ping sockets (SOCK_DGRAM and IPPROTO_ICMP).
+//It does not need root or CAP_NET_RAW, but the group of the process must be in net.ipv4.ping_group_range.
+//Each session has its own socket for each of its identifiers in each network namespace it sends from, bound to the identifier, and the kernel uses it for the pings with the identifier
+func NewUnprivileged(opts ...Option) goping.Pinger {
+	return &dgramPinger{options: newOptions(opts)}
+}
+
+//dgramPinger is the type the implements goping.Pinger interface over ping sockets
+type dgramPinger struct {
+	options
+}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p dgramPinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+
+	//Initialize the channels used in the select stage
+	input, output, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{})
+
+	//Opens the connection in the network namespace of the pinger
+	c, lerr := p.open(netns.Path(p.namespace), pid)
+	if lerr != nil {
+		//Returns error that connection could not be opened
+		err = fmt.Errorf("Connection could not be opened: %v", lerr)
+		return
+	}
+	//Start Sending ICMPRequests to the File Descriptors. Each one has its own receiver of ICMPReplies
+	go p.ping(pid, c, input, output, doneOutput)
+
+	ping, pong, done = input, output, doneOutput
+	return
+}
+
+//dgramKey identifies a ping socket of a session by the path of its network namespace and the identifier of its pings
+type dgramKey struct {
+	ns string
+	id int
+}
+
+//dgramConn is a ping socket of a session in a network namespace, with the socket options currently set.
+//They are changed only when a request needs other values
+type dgramConn struct {
+	fd, ident          int
+	ttl, tos, pmtudisc int
+	//The interface and the mark the socket is bound to. The socket is not shared, so they are socket options, and only the source address is sent with each packet
+	iface string
+	mark  int
+	rts   *routes
+	sent  *stamps //The send times of the requests, for the RTT of their replies
+}
+
+//open opens a ping socket of the session for the pings with the identifier pid in the network namespace ns
+func (p dgramPinger) open(ns string, pid int) (*dgramConn, error) {
+	fd, ident, err := p.openConn(ns, pid)
+	if err != nil {
+		return nil, err
+	}
+	return &dgramConn{fd: fd, ident: ident, ttl: -1, pmtudisc: -1, rts: newRoutes(ns, false, false), sent: newStamps()}, nil
+}
+
+//OpenConn opens a ping socket fd in the network namespace of the pinger. The kernel uses the port the socket is bound to as the ICMP echo identifier.
+//It tries to bind to pid and returns the identifier the kernel assigned when pid is already in use
+func (p dgramPinger) OpenConn(pid int) (fd int, ident int, err error) {
+	return p.openConn(netns.Path(p.namespace), pid)
+}
+
+//openConn opens a ping socket fd in the network namespace ns, as OpenConn. Only the socket is created inside the namespace, it stays there
+func (p dgramPinger) openConn(ns string, pid int) (fd int, ident int, err error) {
+	//Create a ping socket. The groups allowed are read in the namespace, each one has its own
+	if err = netns.Do(ns, func() (err error) {
+		if fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP); err == syscall.EACCES {
+			groups, _ := ioutil.ReadFile(pingGroupRange)
+			err = fmt.Errorf("%w: group %d is not in net.ipv4.ping_group_range [%v]", ErrPingNotPermitted, os.Getgid(), strings.Join(strings.Fields(string(groups)), " "))
+		}
+		return
+	}); err != nil {
+		return 0, 0, err
+	}
+	//Closes the socket if it could not be configured
+	fail := func(err error) (int, int, error) {
+		syscall.Close(fd)
+		return 0, 0, err
+	}
+	//Set the option to receive the ICMP errors in the socket error queue
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_RECVERR, 1); err != nil {
+		return fail(err)
+	}
+	//Set the option to receive the kernel timestamp from each received message
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1); err != nil {
+		return fail(err)
+	}
+	//Increase the socket buffer
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1024*1024); err != nil {
+		return fail(err)
+	}
+	//Set timeout while reading from socket
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fail(err)
+	}
+	//Bind to the pid as identifier. Let the kernel choose one if it is in use by another socket
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Port: pid & 0xffff}); err != nil {
+		if err != syscall.EADDRINUSE {
+			return fail(err)
+		}
+		if err := syscall.Bind(fd, &syscall.SockaddrInet4{}); err != nil {
+			return fail(err)
+		}
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return fail(err)
+	}
+	return fd, sa.(*syscall.SockaddrInet4).Port, nil
+}
+
+//Resolve is the implementation of the method goping.Resolver.Resolve
+func (p dgramPinger) Resolve(host string) (net.IP, error) {
+	return resolve(host)
+}
+
+//ping sends the requests of the session with the first identifier pid through the socket of their network namespace and identifier,
+//starting with the socket c of the namespace of the pinger for pid. The other sockets are opened by their first request.
+//done is closed after the receivers of the sockets stop
+func (p dgramPinger) ping(pid int, c *dgramConn, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var echo icmp.Echo
+	var icmpmsg = icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Code: 0,
+	}
+	var ip net.IP
+	var icmpb []byte
+	var address = make(map[string]net.IP)
+	var addressError = make(map[string]error)
+
+	//The sockets of the session. Each one has its own receiver
+	var conns = map[dgramKey]*dgramConn{{ns: netns.Path(p.namespace), id: pid}: c}
+	var connError = make(map[dgramKey]error)
+	var stop = make(chan struct{})
+	var wg sync.WaitGroup
+	receive := func(id int, c *dgramConn) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.pong(id, c.ident, c.fd, c.sent, out, stop)
+		}()
+	}
+	receive(pid, c)
+
+	for r := range in {
+		//Resolve HostName, when the session did not
+		var err error
+		if ip = r.Dst.To4(); ip == nil {
+			if _, ok := address[r.Req.Host]; !ok {
+				if addr, lerr := p.Resolve(r.Req.Host); lerr != nil {
+					addressError[r.Req.Host] = lerr
+					address[r.Req.Host] = net.IPv4(0, 0, 0, 0)
+				} else {
+					address[r.Req.Host] = addr
+				}
+			}
+			if addressError[r.Req.Host] != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+				continue
+			}
+			ip = address[r.Req.Host]
+		}
+		//Create the target address to use in the SendTo socket method
+		var to syscall.SockaddrInet4
+		copy(to.Addr[:], ip)
+
+		//Opens the socket of the network namespace and the identifier of the request, when the session did not send from it yet
+		key := dgramKey{ns: netns.Path(r.Req.Config.Namespace), id: r.ID}
+		if key.ns == "" {
+			key.ns = netns.Path(p.namespace)
+		}
+		c, ok := conns[key]
+		if !ok && connError[key] == nil {
+			if c, err = p.open(key.ns, r.ID); err != nil {
+				connError[key] = err
+			} else {
+				conns[key] = c
+				receive(r.ID, c)
+			}
+		}
+		if connError[key] != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: connError[key], RTT: math.NaN()}
+			continue
+		}
+
+		//Sets the TTL and TOS of the packets. A TTL lower than 1 uses the kernel default
+		rttl := r.Req.Config.TTL
+		if rttl < 1 {
+			rttl = -1
+		}
+		if rttl != c.ttl {
+			if err = syscall.SetsockoptInt(c.fd, syscall.IPPROTO_IP, syscall.IP_TTL, rttl); err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not set TTL"), RTT: math.NaN()}
+				continue
+			}
+			c.ttl = rttl
+		}
+		if r.Req.Config.TOS != c.tos {
+			if err = syscall.SetsockoptInt(c.fd, syscall.IPPROTO_IP, syscall.IP_TOS, r.Req.Config.TOS); err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not set TOS"), RTT: math.NaN()}
+				continue
+			}
+			c.tos = r.Req.Config.TOS
+		}
+		//Sets the Don't Fragment bit. The probe mode sends packets up to the MTU of the interface, ignoring the path MTU known by the kernel
+		rpmtudisc := syscall.IP_PMTUDISC_DONT
+		if r.Req.Config.DontFragment {
+			rpmtudisc = syscall.IP_PMTUDISC_PROBE
+		}
+		if rpmtudisc != c.pmtudisc {
+			if err = syscall.SetsockoptInt(c.fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, rpmtudisc); err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not set Don't Fragment"), RTT: math.NaN()}
+				continue
+			}
+			c.pmtudisc = rpmtudisc
+		}
+
+		//Binds the socket to the interface and the mark of the request
+		rt, oob, err := c.rts.lookup(r.Req.Config)
+		if err != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: err, RTT: math.NaN()}
+			continue
+		}
+		if rt.iface != c.iface {
+			if err = syscall.BindToDevice(c.fd, rt.iface); err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: fmt.Errorf("Could not bind to interface %v: %v", rt.iface, err), RTT: math.NaN()}
+				continue
+			}
+			c.iface = rt.iface
+		}
+		if rt.mark != c.mark {
+			if err = syscall.SetsockoptInt(c.fd, syscall.SOL_SOCKET, unix.SO_MARK, rt.mark); err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: fmt.Errorf("Could not set mark %v: %v", rt.mark, err), RTT: math.NaN()}
+				continue
+			}
+			c.mark = rt.mark
+		}
+
+		//Built the Data to be send, with the size of the request
+		now := time.Now()
+		echo.Data = p.payload(r.Req.Config, syscall.NsecToTimeval(now.UnixNano()))
+		//The ICMP echo Identifier and the checksum are set by the kernel
+		echo.ID = 0
+		//Get the ICMP echo sequence number
+		echo.Seq = r.Seq
+		//Build the ICMP echo body
+		icmpmsg.Body = &echo
+		//Build bytes of the ICMP echo
+		if icmpb, err = icmpmsg.Marshal(nil); err != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not marshall ICMP Echo"), RTT: math.NaN()}
+			continue
+		}
+		//The RTT of the replies is measured from the send time kept by us, not from the one they echo
+		c.sent.keep(probe{dst: to.Addr, id: uint16(c.ident), seq: uint16(r.Seq)}, now, r.Req.Config.FlowID > 0)
+		//Sending the packet through the network
+		if err = syscall.Sendmsg(c.fd, icmpb, oob, &to, 0); err == syscall.EMSGSIZE {
+			//Packets with the Don't Fragment bit must fit the MTU of the interface
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: goping.ErrMessageTooLong, RTT: math.NaN()}
+			continue
+		} else if err != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not Send Ping over the socket"), RTT: math.NaN()}
+			continue
+		}
+	}
+	//Stops the receivers, which close the sockets
+	close(stop)
+	wg.Wait()
+	close(done)
+}
+
+//pong receives the replies to the socket fd with the identifier ident, and sends them with the identifier gpid known by goping.
+//Their RTT is measured from the send times kept in sent, and from the timestamp echoed in the data only when it was forgotten.
+//It closes the socket when stop is closed
+func (p dgramPinger) pong(gpid, ident int, fd int, sent *stamps, out chan<- goping.RawResponse, stop <-chan struct{}) {
+	//Buffer to receive the ping packet. Big enough for the largest echo data
+	buf := make([]byte, 65536)
+	//Buffer to receive the control message
+	oob := make([]byte, 256)
+	//Size of the timestamp sent in the echo data
+	tvsz := binary.Size(syscall.Timeval{})
+	//Infinite loop to wait for messages
+	for {
+		//If stop is closed then close the socket and exit function
+		select {
+		case <-stop:
+			if err := syscall.Close(fd); err != nil {
+				fmt.Printf("Error calling syscall.Close %v\n", err)
+			}
+			return
+		default:
+		}
+
+		//Receives a message from the socket sent by the kernel.
+		//A pending ICMP error makes the read fail. The error is then read from the error queue
+		flags := 0
+		n, oobn, _, from, err := syscall.Recvmsg(fd, buf, oob, flags)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			flags = syscall.MSG_ERRQUEUE | syscall.MSG_DONTWAIT
+			if n, oobn, _, from, err = syscall.Recvmsg(fd, buf, oob, flags); err != nil {
+				continue
+			}
+		}
+		var endTime = time.Now()
+		sent.sweep(endTime)
+		//The ICMP message received, or the echo request we sent when reading from the error queue
+		if n < 8 || (flags == 0 && ipv4.ICMPType(buf[0]) != ipv4.ICMPTypeEchoReply) {
+			continue
+		}
+		//The identifier was rewritten by the kernel. Translates it back to the pid known by goping
+		if int(binary.BigEndian.Uint16(buf[4:6])) != ident {
+			continue
+		}
+		seq := int(binary.BigEndian.Uint16(buf[6:8]))
+
+		//Parses the Control Messages to find the SO_TIMESTAMP value and the ICMP error
+		//The source of a reply, and the destination of the echo request read from the error queue
+		var perr error
+		sa, ok := from.(*syscall.SockaddrInet4)
+		if !ok {
+			continue
+		}
+		peer := net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3])
+		dst := peer
+		var rx time.Time
+		if cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, m := range cmsgs {
+				switch {
+				case m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_TIMESTAMP:
+					var tv syscall.Timeval
+					binary.Read(bytes.NewReader(m.Data), binary.LittleEndian, &tv)
+					rx = time.Unix(tv.Unix())
+				case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR:
+					var ee sockExtendedErr
+					eesz := int(unsafe.Sizeof(ee))
+					if len(m.Data) < eesz {
+						continue
+					}
+					binary.Read(bytes.NewReader(m.Data), binary.LittleEndian, &ee)
+					if ee.Origin != soEEOriginICMP {
+						continue
+					}
+					//The kernel does not give us the error message, only its type and code.
+					//The original datagram is the echo request we sent, read from the error queue
+					ie := &goping.ICMPError{Proto: goping.ProtocolICMP, Type: int(ee.Type), Code: int(ee.Code), Original: append([]byte(nil), buf[:n]...)}
+					if ipv4.ICMPType(ee.Type) == ipv4.ICMPTypeDestinationUnreachable && ee.Code == 4 {
+						//The next hop MTU of Fragmentation Needed
+						ie.MTU = int(ee.Info)
+					}
+					//The address of the node that sent the error follows the extended error
+					if len(m.Data) >= eesz+syscall.SizeofSockaddrInet4 {
+						a := m.Data[eesz+4 : eesz+8]
+						peer = net.IPv4(a[0], a[1], a[2], a[3])
+					}
+					ie.Router, perr = peer, ie
+				}
+			}
+		}
+		//Local errors read from the error queue are not about a ping
+		if flags != 0 && perr == nil {
+			continue
+		}
+		//The send times of the request, and whether its data has the flow word
+		st, kept := sent.lookup(probe{dst: sa.Addr, id: uint16(ident), seq: uint16(seq)})
+		//Verifies that the echo data came back as it was sent. Without the request, its flow word may be there
+		if flags == 0 && !p.intact(buf[8:n], !kept || st.flow) {
+			perr = goping.ErrCorruptPayload
+		}
+		//The timestamp echoed in the data
+		var echo *syscall.Timeval
+		if n >= 8+tvsz {
+			echo = new(syscall.Timeval)
+			binary.Read(bytes.NewReader(buf[8:8+tvsz]), binary.LittleEndian, echo)
+		}
+		//Computes the RTT from the send time of the request. The RTT of an error is the time until the router answered
+		resp := goping.RawResponse{Seq: seq, ID: gpid, Peer: peer, Dst: dst, RTT: math.NaN(), Err: perr}
+		if kept {
+			d, send, recv := measure(st, endTime, rx, time.Time{})
+			resp.RTT, resp.SendStamp, resp.RecvStamp = float64(d.Nanoseconds())/1e6, send, recv
+			//The host did not echo the timestamp we sent
+			resp.StampMismatch = echo != nil && *echo != st.echo
+		} else if echo != nil {
+			//The request is too old to be kept. The timestamp of the system may have been stepped since it was sent
+			if rx.IsZero() {
+				rx = endTime
+			} else {
+				resp.RecvStamp = goping.StampKernel
+			}
+			resp.RTT = float64(rx.Round(0).Sub(time.Unix(echo.Unix())).Nanoseconds()) / 1e6
+		}
+		resp.ICMPMessage = make([]byte, n)
+		copy(resp.ICMPMessage, buf[:n])
+		//Restores the identifier used by goping
+		binary.BigEndian.PutUint16(resp.ICMPMessage[4:6], uint16(gpid))
+
+		//GoRoutine that sends the raw response to channel out
+		go func(resp goping.RawResponse) {
+			out <- resp
+		}(resp)
+	}
+}
@@ -0,0 +1,69 @@
+package icmpv4
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+//pingAllowed tells whether the group of the process is in net.ipv4.ping_group_range
+func pingAllowed(t *testing.T) bool {
+	b, err := ioutil.ReadFile(pingGroupRange)
+	if err != nil {
+		t.Skipf("Could not read %v: %v", pingGroupRange, err)
+	}
+	r := strings.Fields(string(b))
+	lo, _ := strconv.Atoi(r[0])
+	hi, _ := strconv.Atoi(r[1])
+	return lo <= os.Getgid() && os.Getgid() <= hi
+}
+
+func TestUnprivilegedLoopback(t *testing.T) {
+	if !pingAllowed(t) {
+		t.Skip("The group of the process is not in net.ipv4.ping_group_range")
+	}
+	cfg := goping.Config{Count: 3, Interval: 10 * time.Millisecond, Timeout: time.Second, TTL: 64}
+	g := goping.New(cfg, NewUnprivileged(), nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	go func() {
+		ping <- g.NewRequest("127.0.0.1", nil)
+		close(ping)
+	}()
+	var received int
+	for r := range pong {
+		received++
+		if r.Err != nil {
+			t.Errorf("Error not expected: %v", r.Err)
+			continue
+		}
+		if !r.Peer.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("No match Peer. Expected: [%v], Got: [%v]", "127.0.0.1", r.Peer)
+		}
+		if math.IsNaN(r.RTT) || r.RTT < 0 {
+			t.Errorf("Invalid RTT: %v", r.RTT)
+		}
+	}
+	if received != cfg.Count {
+		t.Errorf("No match responses. Expected: [%v], Got: [%v]", cfg.Count, received)
+	}
+}
+
+func TestUnprivilegedNotPermitted(t *testing.T) {
+	if pingAllowed(t) {
+		t.Skip("The group of the process is in net.ipv4.ping_group_range")
+	}
+	if _, _, err := (dgramPinger{}).OpenConn(os.Getpid()); !errors.Is(err, ErrPingNotPermitted) {
+		t.Errorf("Error Expected: %v Got: %v", ErrPingNotPermitted, err)
+	}
+}
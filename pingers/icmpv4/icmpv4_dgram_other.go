@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package icmpv4
+
+import (
+	"errors"
+
+	"github.com/gracig/goping"
+)
+
+//ErrPingNotPermitted is returned when the process is not allowed to open ping sockets
+var ErrPingNotPermitted = errors.New("Unprivileged ICMP sockets not permitted")
+
+//NewUnprivileged returns a new Pinger that uses unprivileged ping sockets. Only implemented on linux
+func NewUnprivileged(opts ...Option) goping.Pinger {
+	return &dgramPinger{}
+}
+
+//dgramPinger is the type the implements goping.Pinger interface over ping sockets
+type dgramPinger struct{}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p dgramPinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+	err = errors.New("unprivileged icmpv4 pinger is only implemented on linux")
+	return
+}
@@ -7,67 +7,95 @@ import (
 	"fmt"
 	"math"
 	"net"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
 
 	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/netns"
+	"golang.org/x/net/bpf"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
 )
 
-//New returns a new Pinger
-func New() goping.Pinger {
-	return &pinger{syscall: new(syscallWrapper)}
+//New returns a new Pinger. The sessions of the Pinger share its raw sockets, one per shard, and receive the replies to the ICMP identifiers they own
+func New(opts ...Option) goping.Pinger {
+	return &pinger{syscall: new(syscallWrapper), options: newOptions(opts), mux: newDemux()}
 }
 
 //Pinger is the type the implements goping.Pinger interface
 type pinger struct {
 	syscall syscallWrapperInterface //The syscall Wrapper object
+	options
+	mux *demux //The sockets shared by the sessions
 }
 
 //Start is the implementation of the method goping.Pinger.Start
 func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
 
 	//Initialize the channels used in the select stage
-	input, output, doneInput, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{}), make(chan struct{})
+	input, output, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{})
 
-	//Opens the connection
-	if fd, lerr := p.OpenConn(); lerr != nil {
+	//Joins the connections shared by the sessions. The first session opens them and starts receiving ICMPReplies from each File Descriptor
+	conns, lerr := p.mux.join(p)
+	if lerr != nil {
 		//Returns error that connection could not be opened
 		err = fmt.Errorf("Connection could not be opened: %v", lerr)
-	} else {
-		//Start Sending ICMPRequests to the File Descriptor
-		go p.ping(pid, fd, input, output, doneInput)
-		//Start receiving ICMPReplies from the File Descriptor
-		go p.pong(pid, fd, output, doneInput, doneOutput)
-	}
-	if err != nil {
 		return
 	}
+	//Start Sending ICMPRequests to the File Descriptors
+	go p.dispatch(conns, input, output, doneOutput)
 
 	ping, pong, done = input, output, doneOutput
 	return
 }
 
-//Opens a raw socket fd
+//Resolve is the implementation of the method goping.Resolver.Resolve
+func (p pinger) Resolve(host string) (net.IP, error) {
+	return resolve(host)
+}
+
+//Opens a raw socket fd in the network namespace of the pinger
 func (p pinger) OpenConn() (int, error) {
+	return p.openConn(netns.Path(p.namespace))
+}
+
+//openConn opens a raw socket fd in the network namespace ns. Only the socket is created inside the namespace, it stays there
+func (p pinger) openConn(ns string) (int, error) {
 	//Create a raw socket to read icmp packets
-	fd, err := p.syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	var fd int
+	err := netns.Do(ns, func() (err error) {
+		fd, err = p.syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+		return
+	})
 	if err != nil {
 		return 0, err
 	}
+	//Closes the socket when it can not be configured
+	fail := func(err error) (int, error) {
+		p.syscall.Close(fd)
+		return 0, err
+	}
 	//Set the option to receive the kernel timestamp from each received message
 	if err := p.syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1); err != nil {
-		return 0, err
+		return fail(err)
 	}
+	//Ask for the kernel timestamps of the sent packets. Without them, the RTT is measured by the clock of the process
+	p.syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_TIMESTAMPING, timestamping)
 	//Increase the socket buffer
 	if err := p.syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1024*1024); err != nil {
-		return 0, err
+		return fail(err)
 	}
 	//Configure to send IP Header together with the icmp message
 	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_HDRINCL, 1); err != nil {
-		return 0, err
+		return fail(err)
+	}
+
+	//Send the packets up to the MTU of the interface. The path MTU known by the kernel would refuse the larger packets with the Don't Fragment bit, hiding the changes of the path
+	if err := p.syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE); err != nil {
+		return fail(err)
 	}
 
 	//Set timeout while reading from socket
@@ -75,7 +103,7 @@ func (p pinger) OpenConn() (int, error) {
 	tv.Sec = 1 /* 1 sec timeout */
 	tv.Usec = 0
 	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
-		return 0, err
+		return fail(err)
 	}
 	//Listen on all interfaces
 	var addr syscall.Sockaddr = &syscall.SockaddrInet4{
@@ -83,103 +111,298 @@ func (p pinger) OpenConn() (int, error) {
 		Addr: [4]byte{0, 0, 0, 0},
 	}
 	if err := p.syscall.Bind(fd, addr); err != nil {
-		return 0, err
+		return fail(err)
 	}
 	return fd, nil
 }
 
-func (p pinger) ping(gpid int, fd int, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
-	var echo icmp.Echo
-	var icmpmsg = icmp.Message{
-		Type: ipv4.ICMPTypeEcho,
-		Code: 0,
+//dispatch sends each request of the session to the shard of its destination, in the network namespace of the request.
+//Each shard sends its requests through its own socket. conns are the sockets of the namespace of the pinger
+func (p pinger) dispatch(conns []conn, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var address = make(map[string]net.IP)
+	var addressError = make(map[string]error)
+	//The identifiers owned by the session
+	var owned = make(map[int]bool)
+	var ids []int
+
+	//The requests wait for their shard up to the size of a batch, so they are sent together.
+	//The shards of each network namespace, by its path, started with the first request sent from it
+	var nets = make(map[string][]chan goping.SeqRequest)
+	var netError = make(map[string]error)
+	var wg sync.WaitGroup
+	start := func(ns string, conns []conn) {
+		shards := make([]chan goping.SeqRequest, len(conns))
+		for k, c := range conns {
+			shards[k] = make(chan goping.SeqRequest, p.batch)
+			wg.Add(1)
+			go func(c conn, in <-chan goping.SeqRequest) {
+				defer wg.Done()
+				p.ping(ns, c, in, out)
+			}(c, shards[k])
+		}
+		nets[ns] = shards
+	}
+	start(netns.Path(p.namespace), conns)
+
+	for r := range in {
+		//The replies to the identifier are sent to this session. The socket filters accept them before the request is sent
+		if !owned[r.ID] {
+			p.mux.own(r.ID, out)
+			owned[r.ID] = true
+			ids = append(ids, r.ID)
+		}
+		//Resolve HostName, when the session did not
+		if r.Dst.To4() == nil {
+			if _, ok := address[r.Req.Host]; !ok {
+				if addr, lerr := p.Resolve(r.Req.Host); lerr != nil {
+					addressError[r.Req.Host] = lerr
+					address[r.Req.Host] = net.IPv4(0, 0, 0, 0)
+				} else {
+					address[r.Req.Host] = addr
+				}
+			}
+			if addressError[r.Req.Host] != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+				continue
+			}
+			r.Dst = address[r.Req.Host]
+		}
+		//Opens the sockets of the network namespace of the request, when the session did not send from it yet
+		ns := netns.Path(r.Req.Config.Namespace)
+		if ns == "" {
+			ns = netns.Path(p.namespace)
+		}
+		if _, ok := nets[ns]; !ok && netError[ns] == nil {
+			if conns, lerr := p.mux.enter(p, ns); lerr != nil {
+				netError[ns] = lerr
+			} else {
+				start(ns, conns)
+			}
+		}
+		if netError[ns] != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: r.Dst, Err: netError[ns], RTT: math.NaN()}
+			continue
+		}
+		shards := nets[ns]
+		shards[shardOf(r.Dst, len(shards))] <- r
 	}
+	for _, shards := range nets {
+		for _, shard := range shards {
+			close(shard)
+		}
+	}
+	wg.Wait()
+	//The replies to the session are not received anymore
+	p.mux.leave(ids)
+	close(done)
+}
+
+//ping sends the requests of a shard through its socket in the network namespace ns, and keeps their send times.
+//The destination of each request is resolved
+func (p pinger) ping(ns string, c conn, in <-chan goping.SeqRequest, out chan<- goping.RawResponse) {
 	var iph = ipv4.Header{
 		Version:  4,
 		Len:      20,
 		Protocol: 1, // ICMP
 	}
-	var ip net.IP
-	var icmpb, ipv4b []byte
-	var buffer bytes.Buffer
-	var tv syscall.Timeval
 
-	var address = make(map[string]net.IP)
-	var addressError = make(map[string]error)
+	//The packets sent together, and the responses of the ones that could not be sent
+	var b = newBatch(p.batch, 0, 0)
+	var reqs = make([]goping.SeqRequest, 0, p.batch)
+	var failed = make([]goping.RawResponse, p.batch)
+	//The sockets are shared, so the interface and the mark are sent with each packet
+	var rts = newRoutes(ns, true, true)
 
 	for r := range in {
-		//Resolve HostName
-		var err error
-		if _, ok := address[r.Req.Host]; !ok {
-			if addr, lerr := net.ResolveIPAddr("ip4", r.Req.Host); lerr != nil {
-				addressError[r.Req.Host] = lerr
-				address[r.Req.Host] = net.IPv4(0, 0, 0, 0)
-			} else {
-				address[r.Req.Host] = addr.IP.To4()
+		//Takes the requests already waiting, up to the size of the batch
+		reqs = append(reqs[:0], r)
+	collect:
+		for len(reqs) < p.batch {
+			select {
+			case r, ok := <-in:
+				if !ok {
+					break collect
+				}
+				reqs = append(reqs, r)
+			default:
+				break collect
 			}
 		}
-		if addressError[r.Req.Host] != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
-			continue
+		queued := 0
+		for _, r := range reqs {
+			ip := r.Dst.To4()
+			rt, oob, err := rts.lookup(r.Req.Config)
+			if err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: err, RTT: math.NaN()}
+				continue
+			}
+			now := time.Now()
+			pkt, err := p.request(r, ip, rt.src, &iph, now)
+			if err != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: err, RTT: math.NaN()}
+				continue
+			}
+			dst := [4]byte{ip[0], ip[1], ip[2], ip[3]}
+			//The RTT of the replies is measured from the send time kept by us, not from the one they echo
+			c.sent.keep(probe{dst: dst, id: uint16(r.ID), seq: uint16(r.Seq)}, now, r.Req.Config.FlowID > 0)
+			b.set(queued, pkt, dst, oob)
+			failed[queued] = goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, RTT: math.NaN()}
+			queued++
 		}
-		//Create the target address to use in the SendTo socket method
-		ip = address[r.Req.Host]
-		var to syscall.SockaddrInet4
-		to.Port = 0
-		to.Addr[0], to.Addr[1], to.Addr[2], to.Addr[3] = ip[0], ip[1], ip[2], ip[3]
-
-		//Built the Data to be send
-		buffer.Reset()
-		syscall.Gettimeofday(&tv)
-		binary.Write(&buffer, binary.LittleEndian, &tv)
-		echo.Data = buffer.Bytes()
-		//Get the ICMP echo Identifier
-		echo.ID = gpid
-		//Get the ICMP echo sequence number
-		echo.Seq = r.Seq
-		//Build the ICMP echo body
-		icmpmsg.Body = &echo
-		//Build bytes of the ICMP echo
-		if icmpb, err = icmpmsg.Marshal(nil); err != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not marshall ICMP Echo"), RTT: math.NaN()}
-			continue
+		//Sending the packets through the network
+		p.send(c.fd, b, queued, func(i int, err error) {
+			failed[i].Err = err
+			out <- failed[i]
+		})
+	}
+}
+
+//request builds the packet of the echo request r to ip from src sent at now, with the IPv4 header iph. A zero src is filled by the kernel
+func (p pinger) request(r goping.SeqRequest, ip net.IP, src [4]byte, iph *ipv4.Header, now time.Time) ([]byte, error) {
+	//Built the Data to be send, with the size of the request
+	tv := syscall.NsecToTimeval(now.UnixNano())
+	//Build the ICMP echo with the identifier and the sequence number
+	icmpmsg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Code: 0,
+		Body: &icmp.Echo{ID: r.ID, Seq: r.Seq, Data: p.payload(r.Req.Config, tv)},
+	}
+	//Build bytes of the ICMP echo
+	icmpb, err := icmpmsg.Marshal(nil)
+	if err != nil {
+		return nil, errors.New("Could not marshall ICMP Echo")
+	}
+	if r.Req.Config.FlowID > 0 {
+		flowChecksum(icmpb, 8+timestampSize, uint16(r.Req.Config.FlowID%0xffff))
+	}
+	//Builds the ip header
+	iph.TOS = int(r.Req.Config.TOS)
+	iph.TotalLen = 20 + len(icmpb) // 20 bytes for IP, len(wb) for ICMP
+	iph.TTL = int(r.Req.Config.TTL)
+	iph.Flags = 0
+	if r.Req.Config.DontFragment {
+		iph.Flags = ipv4.DontFragment
+	}
+	iph.Dst, iph.Src = ip, nil
+	if src != [4]byte{} {
+		iph.Src = net.IP(src[:])
+	}
+	//Pack IP Header
+	ipv4b, err := iph.Marshal()
+	if err != nil {
+		return nil, errors.New("Could not marshall IP Header")
+	}
+	return append(ipv4b, icmpb...), nil
+}
+
+//send sends the n first packets of the batch b, with sendmmsg, or with sendmsg when the batch holds one packet.
+//fail is called with the position and the error of each packet that could not be sent
+func (p pinger) send(fd int, b *batch, n int, fail func(i int, err error)) {
+	for i := 0; i < n; {
+		var sent int
+		var err error
+		if len(b.msgs) == 1 {
+			if err = p.syscall.Sendmsg(fd, b.bufs[i], b.oobs[i], &syscall.SockaddrInet4{Addr: b.addrs[i].Addr}, 0); err == nil {
+				sent = 1
+			}
+		} else {
+			//sendmmsg stops at the first packet that fails, and reports its error on the next call
+			sent, err = p.syscall.Sendmmsg(fd, b.msgs[i:n], 0)
 		}
-		//Builds the ip header
-		iph.TOS = int(r.Req.Config.TOS)
-		iph.TotalLen = 20 + len(icmpb) // 20 bytes for IP, len(wb) for ICMP
-		iph.TTL = int(r.Req.Config.TTL)
-		iph.Dst = ip
-		//Pack IP Header
-		if ipv4b, err = iph.Marshal(); err != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not marshall IP Header"), RTT: math.NaN()}
-			continue
+		switch {
+		case err == syscall.EINTR:
+			//Interrupted before sending any packet
+		case err == syscall.EMSGSIZE:
+			//The kernel does not fragment the packets with the IP header built by us. They must fit the MTU of the interface
+			fail(i, goping.ErrMessageTooLong)
+			i++
+		case err == syscall.EINVAL && markOf(b.oobs[i]) != 0:
+			//Kernels older than 5.9 refuse the SO_MARK control message, even when the socket option is allowed
+			fail(i, fmt.Errorf("%w: the kernel refused mark %v as a control message. It needs linux 5.9, or the unprivileged pinger", goping.ErrMarkNotSupported, markOf(b.oobs[i])))
+			i++
+		case err != nil:
+			fail(i, errors.New("Could not Send Ping over the socket"))
+			i++
+		default:
+			i += sent
 		}
-		//Sending the packet through the network
-		if err = p.syscall.Sendto(fd, append(ipv4b, icmpb...), 0, &to); err != nil {
-			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not Send Ping over the socket"), RTT: math.NaN()}
-			continue
+	}
+}
+
+//Offsets of the fields inside the received packets. Raw IPv4 sockets receive the IPv4 header. The offsets are the ones after a header
+//without options, and are moved by the length of the options of the received header. The quoted echo requests are ours, sent without options
+const (
+	ipHeaderLen = 20
+	//Echo Reply
+	echoType = ipHeaderLen
+	echoID   = ipHeaderLen + 4
+	echoSeq  = ipHeaderLen + 6
+	echoData = ipHeaderLen + 8
+	//The source address of the reply is the destination of the echo request
+	replySrc = 12
+	//Error messages quote the original IPv4 header after the 8 bytes of the ICMP header
+	quotedProtocol = ipHeaderLen + 8 + 9
+	quotedDst      = ipHeaderLen + 8 + 16
+	quotedICMP     = ipHeaderLen + 8 + ipHeaderLen
+	quotedID       = quotedICMP + 4
+	quotedSeq      = quotedICMP + 6
+	quotedData     = quotedICMP + 8
+)
+
+//parseMessage finds the identifier, the sequence, the destination, the position of the sent timestamp and the error of a received packet.
+//Errors are decoded into a *goping.ICMPError sent by router.
+//Returns false if the packet is not an answer to an echo request
+func parseMessage(router net.IP, buf []byte) (id int, seq int, dst net.IP, data int, perr error, ok bool) {
+	if len(buf) < echoData {
+		return
+	}
+	//The length of the IPv4 header, as the filter reads it
+	hl := int(buf[0]&0x0f) * 4
+	if hl < ipHeaderLen || len(buf) < echoData-ipHeaderLen+hl {
+		return
+	}
+	off := hl - ipHeaderLen
+	switch ipv4.ICMPType(buf[echoType+off]) {
+	case ipv4.ICMPTypeEchoReply:
+		id = int(binary.BigEndian.Uint16(buf[echoID+off:]))
+		seq = int(binary.BigEndian.Uint16(buf[echoSeq+off:]))
+		dst = net.IP(buf[replySrc : replySrc+net.IPv4len])
+		data = echoData + off
+	case ipv4.ICMPTypeEcho:
+		//Our own requests are received when pinging a local address
+		return
+	default:
+		//Only error messages quoting one of our echo requests are accepted
+		if len(buf) < quotedData+off || buf[quotedProtocol+off] != syscall.IPPROTO_ICMP || ipv4.ICMPType(buf[quotedICMP+off]) != ipv4.ICMPTypeEcho {
+			return
 		}
+		id = int(binary.BigEndian.Uint16(buf[quotedID+off:]))
+		seq = int(binary.BigEndian.Uint16(buf[quotedSeq+off:]))
+		dst = net.IP(buf[quotedDst+off : quotedDst+off+net.IPv4len])
+		data = quotedData + off
+		//The message is longer than the ICMP header, so it is always parsed
+		perr, _ = goping.ParseICMPError(goping.ProtocolICMP, router, buf[hl:])
 	}
-	go func() {
-		done <- struct{}{}
-	}()
+	return id, seq, dst, data, perr, true
 }
 
-func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-chan struct{}, done chan<- struct{}) {
-	//Buffer to receive the ping packet
-	buf := make([]byte, 1024)
-	//Buffer to receive the control message
-	oob := make([]byte, 64)
-	//Buffer to read oob
-	var bbuf bytes.Buffer
-	//Variable to get timestamp from oob
-	var tv syscall.Timeval
+//pong receives the replies of every session of the pinger to the destinations of the shard. It closes the socket when stop is closed
+func (p pinger) pong(c conn, shard int, stop <-chan struct{}) {
+	fd := c.fd
+	//Buffers to receive the ping packets and their control messages. Big enough for the largest echo data
+	b := newBatch(p.batch, 65536, 128)
+	//The responses of each batch, by session
+	var sessions []chan<- goping.RawResponse
+	var responses [][]goping.RawResponse
+	//The kernel timestamps of the sent requests are read when the socket takes them
+	flags, err := p.syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_TIMESTAMPING)
+	stamped := err == nil && flags != 0
+	pfd := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
 	//Infinite loop to wait for messages
 	for {
-		//If doneIn is closed then close done channel and exit function
+		//If stop is closed then close the socket and exit function
 		select {
-		case <-donein:
-			close(done)
+		case <-stop:
 			if err := p.syscall.Close(fd); err != nil {
 				fmt.Printf("Error calling syscall.Close %v\n", err)
 			}
@@ -187,72 +410,155 @@ func (p pinger) pong(gpid int, fd int, out chan<- goping.RawResponse, donein <-c
 		default:
 		}
 
-		//Receives a message from the socket sent by the kernel
-		_, oobn, _, from, err := p.syscall.Recvmsg(fd, buf, oob, 0)
-		if err != nil {
-			//fmt.Printf("Error reading recvmsg %v\n", err)
-			//Error reading packaging
+		//Waits for replies, or for send timestamps in the error queue, up to a second
+		if n, err := p.syscall.Poll(pfd, 1000); err != nil || n == 0 {
 			continue
 		}
+		var n int
+		if pfd[0].Revents&unix.POLLIN != 0 {
+			//Receives the messages sent by the kernel
+			var err error
+			if n, err = p.receive(fd, b); err != nil {
+				//Error reading packaging
+				n = 0
+			}
+		}
 		var endTime = time.Now()
-
-		//Finds the pid and seq value.
-		var pid, seq int
-		switch buf[20] {
-		case 0:
-			//Received an Echo Reply
-			pid = int(uint16(buf[24])<<8 | uint16(buf[25]))
-			seq = int(uint16(buf[26])<<8 | uint16(buf[27]))
-		default:
-			//Received an error message
-			pid = int(uint16(buf[52])<<8 | uint16(buf[53]))
-			seq = int(uint16(buf[54])<<8 | uint16(buf[55]))
-			//emsg = msg[28:] //20+28+4
+		//The send timestamps of the received replies were queued before them
+		if stamped {
+			c.sent.drain(p.syscall, fd)
 		}
-		//Blocks processing if pid ! gpid or if packet is not an EchoRequest.
-		if !(pid == gpid && buf[20] != 8) {
-			continue
+		c.sent.sweep(endTime)
+
+		sessions, responses = sessions[:0], responses[:0]
+		for i := 0; i < n; i++ {
+			buf, oob, from := b.packet(i)
+			out, resp, ok := p.reply(buf, oob, from, shard, c.sent, endTime)
+			if !ok {
+				continue
+			}
+			s := 0
+			for s < len(sessions) && sessions[s] != out {
+				s++
+			}
+			if s == len(sessions) {
+				sessions, responses = append(sessions, out), append(responses, nil)
+			}
+			responses[s] = append(responses[s], resp)
 		}
-		//Parses the Control Message to find the SO_TIMESTAMP value
-		cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
-		if err != nil {
-			//Error parsing socket control message
+		//GoRoutine that sends the raw responses of the batch to each session, in the order they were received
+		for s, out := range sessions {
+			go func(out chan<- goping.RawResponse, resps []goping.RawResponse) {
+				for _, resp := range resps {
+					out <- resp
+				}
+			}(out, responses[s])
 		}
-		//Iterate over the control messages
+	}
+}
+
+//receive fills the batch b with the packets waiting in the socket, with recvmmsg,
+//or with recvmsg when the batch holds one packet. Returns the number of packets received
+func (p pinger) receive(fd int, b *batch) (int, error) {
+	b.reset()
+	if len(b.msgs) > 1 {
+		return p.syscall.Recvmmsg(fd, b.msgs, syscall.MSG_DONTWAIT)
+	}
+	n, oobn, _, from, err := p.syscall.Recvmsg(fd, b.bufs[0], b.oobs[0], syscall.MSG_DONTWAIT)
+	if err != nil {
+		return 0, err
+	}
+	sa, ok := from.(*syscall.SockaddrInet4)
+	if !ok {
+		return 0, syscall.EAFNOSUPPORT
+	}
+	b.msgs[0].n = uint32(n)
+	b.msgs[0].hdr.SetControllen(oobn)
+	b.addrs[0].Addr = sa.Addr
+	return 1, nil
+}
+
+//reply builds the raw response of the packet buf received from the address from by the shard, with the control message oob.
+//The RTT is measured from the send times of the request kept in sent. The timestamp echoed in the data is only compared with them,
+//and only used when they were forgotten. endTime is the time the packet was read, with the monotonic clock.
+//Returns the channel of the session that owns its identifier, and false if the packet is not an answer to one of the requests of the shard
+func (p pinger) reply(buf, oob []byte, from [4]byte, shard int, sent *stamps, endTime time.Time) (out chan<- goping.RawResponse, resp goping.RawResponse, ok bool) {
+	//Size of the timestamp sent in the echo data
+	tvsz := binary.Size(syscall.Timeval{})
+	n := len(buf)
+
+	//Get peer address. It is the router that sent an error message
+	peer := net.IPv4(from[0], from[1], from[2], from[3])
+
+	//Finds the identifier and seq value, the position of the sent timestamp and the error. Blocks processing if the packet is not ours
+	id, seq, dst, data, perr, ok := parseMessage(peer, buf)
+	if !ok || shardOf(dst, p.shards) != shard {
+		return nil, resp, false
+	}
+	//Finds the session that owns the identifier
+	if out = p.mux.owner(id); out == nil {
+		return nil, resp, false
+	}
+	//The send times of the request, and whether it carried a flow word
+	st, kept := sent.lookup(probe{dst: [4]byte{dst[0], dst[1], dst[2], dst[3]}, id: uint16(id), seq: uint16(seq)})
+	//Verifies that the echo data came back as it was sent. Without the request, its flow word may be there
+	if perr == nil && !p.intact(buf[data:n], !kept || st.flow) {
+		perr = goping.ErrCorruptPayload
+	}
+	//Parses the Control Message to find the SO_TIMESTAMP value
+	var rx time.Time
+	if cmsgs, err := syscall.ParseSocketControlMessage(oob); err == nil {
 		for _, m := range cmsgs {
-			//Continue if control message is not syscall.SOL_SOCKET
-			if m.Header.Level != syscall.SOL_SOCKET {
-				continue
-			}
-			//Control Message is SOL_SOCKET, Verifyng if syscall is SO_TIMESTAMP
-			switch m.Header.Type {
-			case syscall.SO_TIMESTAMP:
-				bbuf.Write(m.Data)
-				binary.Read(&bbuf, binary.LittleEndian, &tv)
-				bbuf.Reset()
-				endTime = time.Unix(tv.Unix())
+			if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_TIMESTAMP {
+				var tv syscall.Timeval
+				binary.Read(bytes.NewReader(m.Data), binary.LittleEndian, &tv)
+				rx = time.Unix(tv.Unix())
 			}
 		}
-		tv.Sec = 0
-		tv.Usec = 0
-		tvsz := unsafe.Sizeof(&tv)
-		bbuf.Write(buf[28 : 28+tvsz*2])
-		binary.Read(&bbuf, binary.LittleEndian, &tv)
-		bbuf.Reset()
-		var startTime = time.Unix(tv.Unix())
-
-		//Get peer address
-		peer := net.IPv4(
-			from.(*syscall.SockaddrInet4).Addr[0],
-			from.(*syscall.SockaddrInet4).Addr[1],
-			from.(*syscall.SockaddrInet4).Addr[2],
-			from.(*syscall.SockaddrInet4).Addr[3],
-		)
-		//GoRoutine that sends the raw response to channel out
-		go func(seq int, msg []byte, peer net.IP, rtt float64) {
-			out <- goping.RawResponse{Seq: seq, ICMPMessage: msg, Peer: peer, RTT: rtt}
-		}(seq, buf[:40], peer, float64(endTime.Sub(startTime).Nanoseconds())/1e6)
 	}
+	//The hardware timestamp of the reply, when the network card takes them
+	_, rxHard := kernelStamps(oob)
+	//The timestamp echoed in the data. Routers that quote only 8 bytes of the echo request do not echo it
+	var echo *syscall.Timeval
+	if n >= data+tvsz {
+		echo = new(syscall.Timeval)
+		binary.Read(bytes.NewReader(buf[data:data+tvsz]), binary.LittleEndian, echo)
+	}
+	var rtt = math.NaN()
+	if kept {
+		d, send, recv := measure(st, endTime, rx, rxHard)
+		rtt, resp.SendStamp, resp.RecvStamp = float64(d.Nanoseconds())/1e6, send, recv
+		//The host did not echo the timestamp we sent
+		resp.StampMismatch = echo != nil && *echo != st.echo
+	} else if echo != nil {
+		//The request is too old to be kept. The timestamp of the system may have been stepped since it was sent
+		if rx.IsZero() {
+			rx = endTime
+		} else {
+			resp.RecvStamp = goping.StampKernel
+		}
+		rtt = float64(rx.Round(0).Sub(time.Unix(echo.Unix())).Nanoseconds()) / 1e6
+	}
+
+	msg := make([]byte, n)
+	copy(msg, buf)
+	//The destination is copied since buf is reused
+	dst = net.IPv4(dst[0], dst[1], dst[2], dst[3])
+	resp.Seq, resp.ID, resp.ICMPMessage, resp.Peer, resp.Dst, resp.RTT, resp.Err = seq, id, msg, peer, dst, rtt, perr
+	return out, resp, true
+}
+
+//flowChecksum writes at off the 16 bits of data that make the checksum of the ICMP message b equal to sum.
+//Load balancers that hash the first bytes of the ICMP header send every message with the same checksum through the same path.
+//The message must already have its checksum computed, and off must be even
+func flowChecksum(b []byte, off int, sum uint16) {
+	//With a zero compensation word the checksum is ^S, where S is the one's complement sum of the message.
+	//The compensation w must turn S into ^sum, so w = ^sum - S = ^sum + ^S in one's complement arithmetic
+	binary.BigEndian.PutUint16(b[off:], 0)
+	w := uint32(^sum) + uint32(binary.BigEndian.Uint16(b[2:4]))
+	w = (w & 0xffff) + (w >> 16)
+	binary.BigEndian.PutUint16(b[off:], uint16(w))
+	binary.BigEndian.PutUint16(b[2:4], sum)
 }
 
 /*syscallWrapperInterface wraps syscall calls */
@@ -260,9 +566,15 @@ type syscallWrapperInterface interface {
 	Socket(domain, typ, proto int) (fd int, err error)
 	SetsockoptInt(fd, level, opt int, value int) (err error)
 	Bind(fd int, sa syscall.Sockaddr) (err error)
-	Sendto(fd int, p []byte, flags int, to syscall.Sockaddr) (err error)
+	Sendmsg(fd int, p, oob []byte, to syscall.Sockaddr, flags int) (err error)
 	Recvmsg(fd int, p, oob []byte, flags int) (n, oobn int, recvflags int, from syscall.Sockaddr, err error)
 	Close(fd int) (err error)
+	AttachFilter(fd int, prog []bpf.RawInstruction) (err error)
+	DetachFilter(fd int) (err error)
+	Sendmmsg(fd int, msgs []mmsghdr, flags int) (n int, err error)
+	Recvmmsg(fd int, msgs []mmsghdr, flags int) (n int, err error)
+	GetsockoptInt(fd, level, opt int) (value int, err error)
+	Poll(fds []unix.PollFd, timeout int) (n int, err error)
 }
 
 /*syscallWrapper is a type that implements syscallWrapperinterface */
@@ -276,12 +588,20 @@ func (c syscallWrapper) SetsockoptInt(fd, level, opt int, value int) (err error)
 	err = syscall.SetsockoptInt(fd, level, opt, value)
 	return
 }
+func (c syscallWrapper) GetsockoptInt(fd, level, opt int) (value int, err error) {
+	value, err = syscall.GetsockoptInt(fd, level, opt)
+	return
+}
+func (c syscallWrapper) Poll(fds []unix.PollFd, timeout int) (n int, err error) {
+	n, err = unix.Poll(fds, timeout)
+	return
+}
 func (c syscallWrapper) Bind(fd int, sa syscall.Sockaddr) (err error) {
 	err = syscall.Bind(fd, sa)
 	return
 }
-func (c syscallWrapper) Sendto(fd int, p []byte, flags int, to syscall.Sockaddr) (err error) {
-	err = syscall.Sendto(fd, p, flags, to)
+func (c syscallWrapper) Sendmsg(fd int, p, oob []byte, to syscall.Sockaddr, flags int) (err error) {
+	err = syscall.Sendmsg(fd, p, oob, to, flags)
 	return
 }
 func (c syscallWrapper) Recvmsg(fd int, p, oob []byte, flags int) (n, oobn int, recvflags int, from syscall.Sockaddr, err error) {
@@ -292,3 +612,28 @@ func (c syscallWrapper) Close(fd int) (err error) {
 	err = syscall.Close(fd)
 	return
 }
+func (c syscallWrapper) AttachFilter(fd int, prog []bpf.RawInstruction) (err error) {
+	//bpf.RawInstruction has the layout of the kernel sock_filter
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: (*unix.SockFilter)(unsafe.Pointer(&prog[0]))}
+	err = unix.SetsockoptSockFprog(fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &fprog)
+	return
+}
+func (c syscallWrapper) DetachFilter(fd int) (err error) {
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_DETACH_FILTER, 0)
+	return
+}
+func (c syscallWrapper) Sendmmsg(fd int, msgs []mmsghdr, flags int) (n int, err error) {
+	r, _, errno := syscall.Syscall6(unix.SYS_SENDMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+func (c syscallWrapper) Recvmmsg(fd int, msgs []mmsghdr, flags int) (n int, err error) {
+	//Without timeout, the receive timeout of the socket applies
+	r, _, errno := syscall.Syscall6(unix.SYS_RECVMMSG, uintptr(fd), uintptr(unsafe.Pointer(&msgs[0])), uintptr(len(msgs)), uintptr(flags), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
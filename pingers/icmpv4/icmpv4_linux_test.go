@@ -0,0 +1,192 @@
+package icmpv4
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+//packet builds an IPv4 packet carrying the ICMP message m
+func packet(t *testing.T, m icmp.Message) []byte {
+	b, err := m.Marshal(nil)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	h, err := (&ipv4.Header{Version: 4, Len: ipHeaderLen, TotalLen: ipHeaderLen + len(b), TTL: 64, Protocol: 1, Src: net.IPv4(10, 0, 0, 1), Dst: net.IPv4(10, 0, 0, 2)}).Marshal()
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	return append(h, b...)
+}
+
+func TestParseMessage(t *testing.T) {
+	data := make([]byte, 16)
+	echo := packet(t, icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 0x11234, Seq: 7, Data: data}})
+	reply := packet(t, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: 7, Data: data}})
+	exceeded := packet(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: echo}})
+	unreachable := packet(t, icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: 3, Body: &icmp.DstUnreach{Data: echo}})
+	//Routers may quote only the first 8 bytes of the echo request
+	short := packet(t, icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: echo[:quotedData-28]}})
+	//Hosts and routers may answer with IPv4 options, such as Record Route
+	h, err := (&ipv4.Header{Version: 4, Len: ipHeaderLen + 8, TotalLen: len(reply) + 8, TTL: 64, Protocol: 1, Src: net.IPv4(10, 0, 0, 1), Dst: net.IPv4(10, 0, 0, 2), Options: []byte{7, 7, 4, 0, 0, 0, 0, 0}}).Marshal()
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	withOptions := func(b []byte) []byte {
+		return append(append([]byte(nil), h...), b[ipHeaderLen:]...)
+	}
+
+	//The destination of a reply is its source, and the destination of an error is the one of the quoted echo request
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+	tt := []struct {
+		name string
+		buf  []byte
+		dst  net.IP
+		data int
+		err  error
+		ok   bool
+	}{
+		{name: "reply", buf: reply, dst: src, data: echoData, ok: true},
+		{name: "request", buf: echo},
+		{name: "exceeded", buf: exceeded, dst: dst, data: quotedData, err: goping.ErrTimeExceeded, ok: true},
+		{name: "unreachable", buf: unreachable, dst: dst, data: quotedData, err: goping.ErrPortUnreachable, ok: true},
+		{name: "short", buf: short, dst: dst, data: quotedData, err: goping.ErrTimeExceeded, ok: true},
+		{name: "truncated", buf: exceeded[:quotedSeq]},
+		{name: "reply with options", buf: withOptions(reply), dst: src, data: echoData + 8, ok: true},
+		{name: "exceeded with options", buf: withOptions(exceeded), dst: dst, data: quotedData + 8, err: goping.ErrTimeExceeded, ok: true},
+		{name: "truncated with options", buf: withOptions(exceeded)[:quotedSeq+8]},
+		{name: "header too short", buf: append([]byte{0x44}, reply[1:]...)},
+	}
+	router := net.IPv4(10, 0, 0, 1)
+	for _, tc := range tt {
+		id, seq, dst, data, err, ok := parseMessage(router, tc.buf)
+		if ok != tc.ok {
+			t.Errorf("No match ok for %v. Expected: [%v], Got: [%v]", tc.name, tc.ok, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if id != 0x1234 || seq != 7 || !dst.Equal(tc.dst) || data != tc.data || !errors.Is(err, tc.err) {
+			t.Errorf("No match for %v. Expected: [%v 7 %v %v %v], Got: [%v %v %v %v %v]", tc.name, 0x1234, tc.dst, tc.data, tc.err, id, seq, dst, data, err)
+		}
+		//Errors carry the router and the quoted echo request
+		var ie *goping.ICMPError
+		if errors.As(err, &ie) && (!ie.Router.Equal(router) || ie.Original[9] != 1) {
+			t.Errorf("No match ICMP error for %v. Got: [%v %x]", tc.name, ie.Router, ie.Original)
+		}
+	}
+}
+
+func TestFlowChecksum(t *testing.T) {
+	for seq := 0; seq < 100; seq++ {
+		m := icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 1234, Seq: seq, Data: make([]byte, 18)}}
+		binary.LittleEndian.PutUint64(m.Body.(*icmp.Echo).Data, uint64(seq*7919))
+		b, err := m.Marshal(nil)
+		if err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		flowChecksum(b, len(b)-2, 0xbeef)
+		if sum := binary.BigEndian.Uint16(b[2:4]); sum != 0xbeef {
+			t.Fatalf("No match checksum. Expected: [%x], Got: [%x]", 0xbeef, sum)
+		}
+		//The checksum of a valid message, including the checksum field, is zero
+		var s uint32
+		for i := 0; i < len(b); i += 2 {
+			s += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		for s > 0xffff {
+			s = (s & 0xffff) + (s >> 16)
+		}
+		if ^uint16(s) != 0 {
+			t.Errorf("Invalid checksum for seq %v: %x", seq, ^uint16(s))
+		}
+	}
+}
+
+func TestSessionsShareSocket(t *testing.T) {
+	p := New()
+	if fd, err := p.(*pinger).OpenConn(); err != nil {
+		t.Skipf("Raw socket not available: %v", err)
+	} else {
+		syscall.Close(fd)
+	}
+	cfg := goping.Config{Count: 5, Interval: 5 * time.Millisecond, Timeout: time.Second, TTL: 64, Identifiers: 3}
+	hosts := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4"}
+	//Sessions of the same pinger, running at the same time, receive only the replies to their pings
+	var wg sync.WaitGroup
+	for s := 0; s < 3; s++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g := goping.New(cfg, p, nil, nil)
+			ping, pong, err := g.Start(time.Millisecond)
+			if err != nil {
+				t.Errorf("Error not expected: %v", err)
+				return
+			}
+			go func() {
+				for _, host := range hosts {
+					ping <- g.NewRequest(host, nil)
+				}
+				close(ping)
+			}()
+			received := make(map[string]int)
+			for r := range pong {
+				if r.Err != nil || r.Duplicate || r.Late || !r.Peer.Equal(net.ParseIP(r.Request.Host)) {
+					t.Errorf("Unexpected response of %v: [%v %v %v %v]", r.Request.Host, r.Err, r.Duplicate, r.Late, r.Peer)
+					continue
+				}
+				received[r.Request.Host]++
+			}
+			for _, host := range hosts {
+				if received[host] != cfg.Count {
+					t.Errorf("No match replies of %v. Expected: [%v], Got: [%v]", host, cfg.Count, received[host])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	//The socket is closed after the last session and opened again by the next one
+	if p.(*pinger).mux.sessions != 0 {
+		t.Errorf("No match sessions. Expected: [0], Got: [%v]", p.(*pinger).mux.sessions)
+	}
+}
+
+//closingSyscall opens UDP sockets in place of raw ones, fails to bind them, and records the sockets closed
+type closingSyscall struct {
+	syscallWrapper
+	closed *[]int
+}
+
+func (s closingSyscall) Socket(domain, typ, proto int) (int, error) {
+	return syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+}
+
+func (s closingSyscall) Bind(fd int, sa syscall.Sockaddr) error {
+	return syscall.EADDRINUSE
+}
+
+func (s closingSyscall) Close(fd int) error {
+	*s.closed = append(*s.closed, fd)
+	return syscall.Close(fd)
+}
+
+func TestOpenConnCloses(t *testing.T) {
+	var closed []int
+	p := New().(*pinger)
+	p.syscall = closingSyscall{closed: &closed}
+	//The socket is closed whichever option fails
+	fd, err := p.openConn("")
+	if err == nil || fd != 0 || len(closed) != 1 {
+		t.Errorf("No match for a failed open. Expected: [error 0 1], Got: [%v %v %v]", err, fd, len(closed))
+	}
+}
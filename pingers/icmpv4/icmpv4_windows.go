@@ -16,12 +16,14 @@ import (
 	"github.com/gracig/goping"
 )
 
-func New() goping.Pinger {
-	return &pinger{}
+//New returns a new Pinger. The windows pinger sends only the timestamp as echo data, so Config.PacketSize and the Fill option are ignored
+func New(opts ...Option) goping.Pinger {
+	return &pinger{options: newOptions(opts)}
 }
 
 //Pinger is the type the implements goping.Pinger interface
 type pinger struct {
+	options
 }
 
 //Start is the implementation of the method goping.Pinger.Start
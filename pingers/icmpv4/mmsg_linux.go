@@ -0,0 +1,80 @@
+package icmpv4
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+/*** Batches ***/
+
+//mmsghdr is the header of each message of sendmmsg and recvmmsg, followed by the number of bytes sent or received
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	n   uint32
+}
+
+//batch holds the messages sent or received by one syscall. The headers point to the buffers of the batch and are reused between calls
+type batch struct {
+	msgs  []mmsghdr
+	iovs  []syscall.Iovec
+	addrs []syscall.RawSockaddrInet4
+	bufs  [][]byte //The packets
+	oobs  [][]byte //The control messages of the packets
+}
+
+//newBatch returns a batch of n messages. Received messages have buffers of size bytes and control buffers of oob bytes.
+//Batches of sent messages have no buffers, each packet and its control messages are set before sending it
+func newBatch(n, size, oob int) *batch {
+	b := &batch{
+		msgs:  make([]mmsghdr, n),
+		iovs:  make([]syscall.Iovec, n),
+		addrs: make([]syscall.RawSockaddrInet4, n),
+		bufs:  make([][]byte, n),
+		oobs:  make([][]byte, n),
+	}
+	for i := range b.msgs {
+		if size > 0 {
+			b.bufs[i] = make([]byte, size)
+		}
+		if oob > 0 {
+			b.oobs[i] = make([]byte, oob)
+		}
+		b.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&b.addrs[i]))
+		b.msgs[i].hdr.Iov = &b.iovs[i]
+		b.msgs[i].hdr.Iovlen = 1
+	}
+	return b
+}
+
+//set puts in the message i the packet pkt, sent to the address to with the control messages oob, which may be nil
+func (b *batch) set(i int, pkt []byte, to [4]byte, oob []byte) {
+	b.bufs[i], b.oobs[i] = pkt, oob
+	b.iovs[i].Base = &pkt[0]
+	b.iovs[i].SetLen(len(pkt))
+	b.addrs[i] = syscall.RawSockaddrInet4{Family: syscall.AF_INET, Addr: to}
+	b.msgs[i].hdr.Namelen = syscall.SizeofSockaddrInet4
+	b.msgs[i].hdr.Control = nil
+	b.msgs[i].hdr.SetControllen(0)
+	if len(oob) > 0 {
+		b.msgs[i].hdr.Control = &oob[0]
+		b.msgs[i].hdr.SetControllen(len(oob))
+	}
+}
+
+//reset prepares every message to be received. The kernel overwrites the lengths of the address and of the control message
+func (b *batch) reset() {
+	for i := range b.msgs {
+		b.iovs[i].Base = &b.bufs[i][0]
+		b.iovs[i].SetLen(len(b.bufs[i]))
+		b.msgs[i].hdr.Namelen = syscall.SizeofSockaddrInet4
+		b.msgs[i].hdr.Control = &b.oobs[i][0]
+		b.msgs[i].hdr.SetControllen(len(b.oobs[i]))
+		b.msgs[i].hdr.Flags = 0
+		b.msgs[i].n = 0
+	}
+}
+
+//packet returns the packet, the control message and the source address of the received message i
+func (b *batch) packet(i int) (buf, oob []byte, from [4]byte) {
+	return b.bufs[i][:b.msgs[i].n], b.oobs[i][:b.msgs[i].hdr.Controllen], b.addrs[i].Addr
+}
@@ -0,0 +1,145 @@
+package icmpv4
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+//rawAvailable skips the test when the raw socket cannot be opened
+func rawAvailable(tb testing.TB, p goping.Pinger) {
+	fd, err := p.(*pinger).OpenConn()
+	if err != nil {
+		tb.Skipf("Raw socket not available: %v", err)
+	}
+	syscall.Close(fd)
+}
+
+//flood sends n echo requests to the loopback addresses dsts in turn through the pinger p, from several goroutines, keeping at most window of them without reply.
+//The requests stop after the replies are received, as a session does. Returns the replies received
+func flood(tb testing.TB, p goping.Pinger, dsts []net.IP, n, window int) int {
+	ping, pong, done, err := p.Start(0x4242)
+	if err != nil {
+		tb.Fatalf("Error not expected: %v", err)
+	}
+	req := goping.Request{Host: "127.0.0.1", Config: goping.Config{TTL: 64}}
+	//Each reply lets another request be sent
+	tokens := make(chan struct{}, window)
+	for i := 0; i < window; i++ {
+		tokens <- struct{}{}
+	}
+	seqs, stop, fed := make(chan int), make(chan struct{}), make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 64; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := range seqs {
+				ping <- goping.SeqRequest{Seq: seq, Req: req, Dst: dsts[seq%len(dsts)], ID: 0x4242}
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for i := 0; i < n; i++ {
+			select {
+			case <-tokens:
+			case <-stop:
+				break feed
+			}
+			seqs <- i % 65536
+		}
+		close(seqs)
+		wg.Wait()
+		close(fed)
+	}()
+	received := 0
+	for received < n {
+		select {
+		case r := <-pong:
+			if r.Err != nil {
+				tb.Errorf("Error not expected: %v", r.Err)
+			}
+			received++
+			tokens <- struct{}{}
+		case <-time.After(2 * time.Second):
+			//The replies dropped by the kernel are not sent again
+			tb.Logf("%v replies lost", n-received)
+			close(stop)
+			return finish(ping, fed, done, received)
+		}
+	}
+	return finish(ping, fed, done, received)
+}
+
+func TestBatchLoopback(t *testing.T) {
+	for _, n := range []int{1, 16} {
+		p := New(Batch(n))
+		rawAvailable(t, p)
+		if received := flood(t, p, loopback(1), 2000, 64); received != 2000 {
+			t.Errorf("No match replies with batch %v. Expected: [%v], Got: [%v]", n, 2000, received)
+		}
+	}
+}
+
+//BenchmarkLoopback measures the packets per second of the raw pinger pinging the loopback, with each batch size
+func BenchmarkLoopback(b *testing.B) {
+	for _, n := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("batch=%v", n), func(b *testing.B) {
+			p := New(Batch(n))
+			rawAvailable(b, p)
+			b.ResetTimer()
+			start := time.Now()
+			received := flood(b, p, loopback(1), b.N, 512)
+			b.ReportMetric(float64(received)/time.Since(start).Seconds(), "pkts/s")
+		})
+	}
+}
+
+func TestShardsLoopback(t *testing.T) {
+	p := New(Shards(4), Batch(8))
+	rawAvailable(t, p)
+	//Each reply is received once, by the shard of its destination
+	if received := flood(t, p, loopback(16), 4000, 64); received != 4000 {
+		t.Errorf("No match replies. Expected: [%v], Got: [%v]", 4000, received)
+	}
+	if p.(*pinger).mux.sessions != 0 {
+		t.Errorf("No match sessions. Expected: [0], Got: [%v]", p.(*pinger).mux.sessions)
+	}
+}
+
+//BenchmarkShards measures the packets per second of the raw pinger pinging 64 loopback addresses, with each number of shards
+func BenchmarkShards(b *testing.B) {
+	for _, n := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("shards=%v", n), func(b *testing.B) {
+			p := New(Shards(n), Batch(8))
+			rawAvailable(b, p)
+			b.ResetTimer()
+			start := time.Now()
+			received := flood(b, p, loopback(64), b.N, 512)
+			b.ReportMetric(float64(received)/time.Since(start).Seconds(), "pkts/s")
+		})
+	}
+}
+
+//loopback returns the n first addresses of 127.0.0.0/8, from 127.0.0.1
+func loopback(n int) []net.IP {
+	dsts := make([]net.IP, n)
+	for i := range dsts {
+		dsts[i] = net.IPv4(127, 0, 0, byte(i+1))
+	}
+	return dsts
+}
+
+//finish stops the requests after every request was given to the pinger, and waits for the pinger to stop
+func finish(ping chan<- goping.SeqRequest, fed, done <-chan struct{}, received int) int {
+	<-fed
+	close(ping)
+	<-done
+	return received
+}
@@ -0,0 +1,110 @@
+package icmpv4
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/netns"
+)
+
+//namespaces creates two network namespaces joined by a veth pair: gpa0 with 10.213.0.1 in the first one, and gpb0 with 10.213.0.2
+//in the second one. Ping sockets are allowed in both. They are deleted when the test ends. Skips the test when they can not be created
+func namespaces(t *testing.T) (a, b string) {
+	a, b = fmt.Sprintf("goping-a-%d", os.Getpid()), fmt.Sprintf("goping-b-%d", os.Getpid())
+	ip := func(args ...string) error {
+		if out, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("ip %v: %v %s", args, err, out)
+		}
+		return nil
+	}
+	for _, ns := range []string{a, b} {
+		if err := ip("netns", "add", ns); err != nil {
+			t.Skipf("Could not create network namespace: %v", err)
+		}
+		ns := ns
+		t.Cleanup(func() { ip("netns", "del", ns) })
+	}
+	for _, args := range [][]string{
+		{"link", "add", "gpa0", "netns", a, "type", "veth", "peer", "name", "gpb0", "netns", b},
+		{"-n", a, "addr", "add", "10.213.0.1/24", "dev", "gpa0"},
+		{"-n", b, "addr", "add", "10.213.0.2/24", "dev", "gpb0"},
+		{"-n", a, "link", "set", "gpa0", "up"},
+		{"-n", b, "link", "set", "gpb0", "up"},
+	} {
+		if err := ip(args...); err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+	}
+	for _, ns := range []string{a, b} {
+		if err := netns.Do(ns, func() error {
+			return ioutil.WriteFile(pingGroupRange, []byte(fmt.Sprintf("%d %d", os.Getgid(), os.Getgid())), 0644)
+		}); err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+	}
+	return a, b
+}
+
+func TestNamespaces(t *testing.T) {
+	a, b := namespaces(t)
+	tt := []struct {
+		name      string
+		host      string
+		namespace string
+		iface     string
+		peer      net.IP
+		err       error
+	}{
+		{name: "pinger", host: "10.213.0.2", peer: net.IPv4(10, 213, 0, 2)},
+		{name: "path", host: "10.213.0.1", namespace: netns.Path(b), peer: net.IPv4(10, 213, 0, 1)},
+		{name: "interface", host: "10.213.0.2", iface: "gpa0", peer: net.IPv4(10, 213, 0, 2)},
+		//The interface is in the namespace of the pinger
+		{name: "interface of another namespace", host: "10.213.0.1", namespace: b, iface: "gpa0", err: goping.ErrNoSuchInterface},
+		{name: "no namespace", host: "10.213.0.1", namespace: "goping-none", err: os.ErrNotExist},
+	}
+	//The pingers are in the first namespace, and each session fans out to the second one
+	for _, p := range []goping.Pinger{New(Namespace(a)), NewUnprivileged(Namespace(a))} {
+		cfg := goping.Config{Count: 2, Interval: 10 * time.Millisecond, Timeout: time.Second, TTL: 64}
+		g := goping.New(cfg, p, nil, nil)
+		ping, pong, err := g.Start(time.Millisecond)
+		if err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		go func() {
+			for i, tc := range tt {
+				r := g.NewRequest(tc.host, map[string]string{"case": fmt.Sprint(i)})
+				r.Config.Namespace, r.Config.Interface = tc.namespace, tc.iface
+				ping <- r
+			}
+			close(ping)
+		}()
+		responses := make(map[string]int)
+		for r := range pong {
+			var i int
+			fmt.Sscan(r.Request.UserData["case"], &i)
+			tc := tt[i]
+			responses[tc.name]++
+			if tc.err != nil {
+				if !errors.Is(r.Err, tc.err) {
+					t.Errorf("No match error for %v with %T. Expected: [%v], Got: [%v]", tc.name, p, tc.err, r.Err)
+				}
+				continue
+			}
+			if r.Err != nil || !r.Peer.Equal(tc.peer) {
+				t.Errorf("No match reply for %v with %T. Expected: [%v], Got: [%v %v]", tc.name, p, tc.peer, r.Peer, r.Err)
+			}
+		}
+		for _, tc := range tt {
+			if responses[tc.name] != cfg.Count {
+				t.Errorf("No match responses for %v with %T. Expected: [%v], Got: [%v]", tc.name, p, cfg.Count, responses[tc.name])
+			}
+		}
+	}
+}
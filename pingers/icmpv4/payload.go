@@ -0,0 +1,192 @@
+package icmpv4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"syscall"
+
+	"github.com/gracig/goping"
+)
+
+/*** Payload ***/
+
+//The echo data starts with the send timestamp, followed by the word that keeps the checksum of a flow constant when Config.FlowID is greater than 0.
+//The rest of the data is filled by the Pattern of the pinger
+var timestampSize = binary.Size(syscall.Timeval{})
+
+const flowWordSize = 2
+
+//Pattern fills the echo data b. The pattern must always produce the same bytes for the same seed and length, since replies are verified by filling it again.
+//The timestamp and the flow word are written over the first bytes after the pattern is filled
+type Pattern func(b []byte, seed uint64)
+
+//Zeros fills the echo data with zeros. It is the default pattern
+func Zeros() Pattern {
+	return func(b []byte, _ uint64) {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+//Fixed fills the echo data with the byte c
+func Fixed(c byte) Pattern {
+	return func(b []byte, _ uint64) {
+		for i := range b {
+			b[i] = c
+		}
+	}
+}
+
+//Incrementing fills each byte of the echo data with its position, wrapping at 256
+func Incrementing() Pattern {
+	return func(b []byte, _ uint64) {
+		for i := range b {
+			b[i] = byte(i)
+		}
+	}
+}
+
+//Random fills the echo data with pseudo random bytes, seeded by the timestamp of each ping
+func Random() Pattern {
+	return func(b []byte, seed uint64) {
+		//xorshift64*. The state must not be zero
+		x := seed | 1
+		for i := range b {
+			x ^= x >> 12
+			x ^= x << 25
+			x ^= x >> 27
+			b[i] = byte((x * 2685821657736338717) >> 56)
+		}
+	}
+}
+
+//Hex fills the echo data repeating up to 16 bytes given as hex digits, as the -p option of ping
+func Hex(s string) (Pattern, error) {
+	if len(s)%2 == 1 {
+		s += "0"
+	}
+	p, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("Invalid hex pattern")
+	}
+	if len(p) == 0 || len(p) > 16 {
+		return nil, errors.New("The hex pattern must have from 1 to 16 bytes")
+	}
+	return func(b []byte, _ uint64) {
+		for i := range b {
+			b[i] = p[i%len(p)]
+		}
+	}, nil
+}
+
+//Option configures the Pinger returned by New and NewUnprivileged
+type Option func(*options)
+
+//options are the settings shared by the icmpv4 pingers
+type options struct {
+	pattern   Pattern
+	batch     int
+	shards    int
+	namespace string
+}
+
+//Fill sets the pattern of the echo data. Replies whose data does not match the pattern are reported with goping.ErrCorruptPayload
+func Fill(p Pattern) Option {
+	return func(o *options) {
+		o.pattern = p
+	}
+}
+
+//Batch sets the number of packets sent by each sendmmsg and received by each recvmmsg of the raw linux pinger.
+//Requests waiting to be sent are taken together, up to n, and so are the replies waiting in the socket.
+//The default 1 sends and receives each packet with its own syscall. Each packet of the batch takes a 64KB receive buffer.
+//The other pingers ignore it
+func Batch(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.batch = n
+		}
+	}
+}
+
+//Shards sets the number of raw sockets of the raw linux pinger. Each socket sends the requests to its share of the destinations,
+//chosen by their address, and has its own receiver for their replies. The kernel filter of each socket only accepts the replies
+//of its destinations. The default is 1. The other pingers ignore it
+func Shards(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.shards = n
+		}
+	}
+}
+
+//Namespace opens the sockets of the linux pingers inside the network namespace ns, named by ip netns or given by its path,
+//as /var/run/netns/blue. Requests with an empty Config.Namespace are sent from it. The rest of goping, like the resolution of the hosts,
+//runs in the namespace of the process. Entering a namespace needs CAP_SYS_ADMIN. The other pingers ignore it
+func Namespace(ns string) Option {
+	return func(o *options) {
+		o.namespace = ns
+	}
+}
+
+//newOptions returns the options with the defaults and opts applied
+func newOptions(opts []Option) options {
+	o := options{pattern: Zeros(), batch: 1, shards: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+//DataSize returns the size of the echo data sent for a request with cfg, without the 8 bytes of the ICMP header, as the -s option of ping.
+//It is cfg.PacketSize, raised to the size of the timestamp, and of the flow word when cfg.FlowID is greater than 0
+func DataSize(cfg goping.Config) int {
+	min := timestampSize
+	if cfg.FlowID > 0 {
+		min += flowWordSize
+	}
+	if cfg.PacketSize < min {
+		return min
+	}
+	return cfg.PacketSize
+}
+
+//seed returns the seed of the pattern of the echo data with the timestamp ts
+func seed(ts []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(ts)
+	return h.Sum64()
+}
+
+//payload returns the echo data of a request with cfg, filled by the pattern and starting with the timestamp tv
+func (o options) payload(cfg goping.Config, tv syscall.Timeval) []byte {
+	var ts bytes.Buffer
+	binary.Write(&ts, binary.LittleEndian, &tv)
+	data := make([]byte, DataSize(cfg))
+	o.pattern(data, seed(ts.Bytes()))
+	copy(data, ts.Bytes())
+	//The flow word is written by flowChecksum after the message is marshalled, and must be zero before it
+	if cfg.FlowID > 0 {
+		data[timestampSize], data[timestampSize+1] = 0, 0
+	}
+	return data
+}
+
+//intact reports whether the echo data of a reply is the data sent. The timestamp is not verified, nor the flow word when flow tells
+//that the request carried one, because its value depends on the rest of the message
+func (o options) intact(data []byte, flow bool) bool {
+	skip := timestampSize
+	if flow {
+		skip += flowWordSize
+	}
+	if len(data) <= skip {
+		return true
+	}
+	want := make([]byte, len(data))
+	o.pattern(want, seed(data[:timestampSize]))
+	return bytes.Equal(data[skip:], want[skip:])
+}
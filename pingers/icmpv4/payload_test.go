@@ -0,0 +1,87 @@
+package icmpv4
+
+import (
+	"bytes"
+	"syscall"
+	"testing"
+
+	"github.com/gracig/goping"
+)
+
+func TestDataSize(t *testing.T) {
+	tt := []struct {
+		cfg  goping.Config
+		size int
+	}{
+		{cfg: goping.Config{}, size: timestampSize},
+		{cfg: goping.Config{PacketSize: 56}, size: 56},
+		{cfg: goping.Config{PacketSize: 1}, size: timestampSize},
+		{cfg: goping.Config{PacketSize: 1, FlowID: 1}, size: timestampSize + flowWordSize},
+		{cfg: goping.Config{PacketSize: 1400, FlowID: 1}, size: 1400},
+	}
+	for _, tc := range tt {
+		if size := DataSize(tc.cfg); size != tc.size {
+			t.Errorf("No match size for %+v. Expected: [%v], Got: [%v]", tc.cfg, tc.size, size)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	hex, err := Hex("ff0")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	patterns := map[string]Pattern{"zeros": Zeros(), "fixed": Fixed(0xa5), "incrementing": Incrementing(), "random": Random(), "hex": hex}
+	tv := syscall.Timeval{Sec: 1500000000, Usec: 123456}
+	for name, p := range patterns {
+		o := newOptions([]Option{Fill(p)})
+		for _, cfg := range []goping.Config{{PacketSize: 301}, {PacketSize: 57, FlowID: 3}, {}} {
+			data := o.payload(cfg, tv)
+			if len(data) != DataSize(cfg) {
+				t.Errorf("No match size of %v. Expected: [%v], Got: [%v]", name, DataSize(cfg), len(data))
+			}
+			if !o.intact(data, cfg.FlowID > 0) {
+				t.Errorf("Payload of %v with %+v should be intact", name, cfg)
+			}
+			//Truncated replies are verified up to their size
+			if !o.intact(data[:len(data)/2], cfg.FlowID > 0) {
+				t.Errorf("Truncated payload of %v with %+v should be intact", name, cfg)
+			}
+			if len(data) <= timestampSize+flowWordSize {
+				continue
+			}
+			corrupt := append([]byte(nil), data...)
+			corrupt[len(corrupt)-1] ^= 0x10
+			if o.intact(corrupt, cfg.FlowID > 0) {
+				t.Errorf("Corrupted payload of %v with %+v should not be intact", name, cfg)
+			}
+			//Without FlowID, the word after the timestamp is part of the pattern
+			corrupt = append([]byte(nil), data...)
+			corrupt[timestampSize] ^= 0x10
+			corrupt[timestampSize+1] ^= 0x01
+			if intact := o.intact(corrupt, cfg.FlowID > 0); intact != (cfg.FlowID > 0) {
+				t.Errorf("No match intact of %v with %+v and a corrupted flow word. Expected: [%v], Got: [%v]", name, cfg, cfg.FlowID > 0, intact)
+			}
+		}
+	}
+
+	//The pattern is repeated after the timestamp
+	data := newOptions([]Option{Fill(hex)}).payload(goping.Config{PacketSize: 40}, tv)
+	if !bytes.Equal(data[32:36], []byte{0xff, 0x00, 0xff, 0x00}) {
+		t.Errorf("No match hex pattern. Got: [%x]", data)
+	}
+	//Random payloads change with the timestamp
+	r := newOptions([]Option{Fill(Random())})
+	a, b := r.payload(goping.Config{PacketSize: 64}, tv), r.payload(goping.Config{PacketSize: 64}, syscall.Timeval{Sec: 1500000000, Usec: 123457})
+	if bytes.Equal(a[timestampSize:], b[timestampSize:]) {
+		t.Errorf("Random payloads should differ")
+	}
+}
+
+func TestHex(t *testing.T) {
+	for _, s := range []string{"", "zz", "00112233445566778899aabbccddeeff00"} {
+		if _, err := Hex(s); err == nil {
+			t.Errorf("Expected an error for pattern %q", s)
+		}
+	}
+}
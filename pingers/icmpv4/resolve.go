@@ -0,0 +1,12 @@
+package icmpv4
+
+import "net"
+
+//resolve returns the IPv4 address of host
+func resolve(host string) (net.IP, error) {
+	addr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP.To4(), nil
+}
@@ -0,0 +1,174 @@
+package icmpv4
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/netns"
+	"golang.org/x/sys/unix"
+)
+
+/*** Source ***/
+
+//binding is the source address, the interface and the mark of the requests of a Config
+type binding struct {
+	src   [4]byte //Zero lets the kernel choose the source address
+	iface string
+	mark  int
+}
+
+//route is a binding validated by the kernel, with the index of its interface
+type route struct {
+	binding
+	ifindex int
+}
+
+//bindingOf returns the binding of the requests with the Config cfg
+func bindingOf(cfg goping.Config) (b binding, err error) {
+	if cfg.Source != nil {
+		ip := cfg.Source.To4()
+		if ip == nil {
+			return b, fmt.Errorf("%w: %v is not an IPv4 address", goping.ErrSourceNotLocal, cfg.Source)
+		}
+		copy(b.src[:], ip)
+	}
+	b.iface, b.mark = cfg.Interface, cfg.Mark
+	return b, nil
+}
+
+//empty tells whether the binding leaves the source address, the interface and the mark to the kernel
+func (b binding) empty() bool {
+	return b == binding{}
+}
+
+//check validates the binding with a socket of the network namespace ns bound as the requests would be: to the interface,
+//to the source address and with the mark. The kernel refuses a source address that is not local to the namespace, or to the VRF of the interface
+func (b binding) check(ns string) (rt route, err error) {
+	rt.binding = b
+	var fd int
+	if err = netns.Do(ns, func() (err error) {
+		fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+		return
+	}); err != nil {
+		return rt, err
+	}
+	defer syscall.Close(fd)
+	if b.iface != "" {
+		ifr, err := unix.NewIfreq(b.iface)
+		if err != nil {
+			return rt, fmt.Errorf("%w: %v", goping.ErrNoSuchInterface, b.iface)
+		}
+		if err := unix.IoctlIfreq(fd, unix.SIOCGIFINDEX, ifr); err != nil {
+			return rt, fmt.Errorf("%w: %v", goping.ErrNoSuchInterface, b.iface)
+		}
+		rt.ifindex = int(ifr.Uint32())
+		if err := syscall.BindToDevice(fd, b.iface); err != nil {
+			return rt, fmt.Errorf("Could not bind to interface %v: %v", b.iface, err)
+		}
+	}
+	if b.mark != 0 {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_MARK, b.mark); err != nil {
+			return rt, fmt.Errorf("Could not set mark %v: %v", b.mark, err)
+		}
+	}
+	if b.src != [4]byte{} {
+		if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: b.src}); errors.Is(err, syscall.EADDRNOTAVAIL) {
+			if b.iface != "" {
+				return rt, fmt.Errorf("%w: %v on %v", goping.ErrSourceNotLocal, net.IP(b.src[:]), b.iface)
+			}
+			return rt, fmt.Errorf("%w: %v", goping.ErrSourceNotLocal, net.IP(b.src[:]))
+		} else if err != nil {
+			return rt, fmt.Errorf("Could not bind to %v: %v", net.IP(b.src[:]), err)
+		}
+	}
+	return rt, nil
+}
+
+//control returns the control messages that send a packet through the route: IP_PKTINFO with the interface and the source address
+//when they are set, followed by SO_MARK when the mark is set. nil when none is needed.
+//The raw sockets are shared by every session, so they can not be bound with SO_BINDTODEVICE. The interface of IP_PKTINFO is the output
+//interface of the route lookup, as the one of SO_BINDTODEVICE: the packet leaves through it even when the best route to the destination
+//uses another interface, and the routes of the VRF of the interface are used. Unlike SO_BINDTODEVICE, it does not filter the replies by the
+//interface they arrive on
+func (rt route) control(ifindex, mark bool) []byte {
+	var oob []byte
+	if (ifindex && rt.ifindex != 0) || rt.src != [4]byte{} {
+		var pi syscall.Inet4Pktinfo
+		if ifindex {
+			pi.Ifindex = int32(rt.ifindex)
+		}
+		//The source address of the route lookup. The raw pinger also writes it in the IP header
+		pi.Spec_dst = rt.src
+		oob = appendCmsg(oob, syscall.IPPROTO_IP, syscall.IP_PKTINFO, (*[syscall.SizeofInet4Pktinfo]byte)(unsafe.Pointer(&pi))[:])
+	}
+	if mark && rt.mark != 0 {
+		var m [4]byte
+		*(*int32)(unsafe.Pointer(&m[0])) = int32(rt.mark)
+		oob = appendCmsg(oob, syscall.SOL_SOCKET, unix.SO_MARK, m[:])
+	}
+	return oob
+}
+
+//markOf returns the mark of the SO_MARK control message in oob. Zero when there is none
+func markOf(oob []byte) int {
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, m := range cmsgs {
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == unix.SO_MARK && len(m.Data) >= 4 {
+			return int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		}
+	}
+	return 0
+}
+
+//appendCmsg appends to oob the control message of the level and type with data
+func appendCmsg(oob []byte, level, typ int, data []byte) []byte {
+	b := make([]byte, syscall.CmsgSpace(len(data)))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = int32(level), int32(typ)
+	h.SetLen(syscall.CmsgLen(len(data)))
+	copy(b[syscall.CmsgLen(0):], data)
+	return append(oob, b...)
+}
+
+//routes validates the bindings of the requests of a session in a network namespace, once for each binding, and keeps their control messages
+type routes struct {
+	known         map[binding]routed
+	ns            string
+	ifindex, mark bool //The control messages carry the interface and the mark
+}
+
+//routed is the route of a binding with its control messages, or the error that made the binding invalid
+type routed struct {
+	rt  route
+	oob []byte
+	err error
+}
+
+//newRoutes returns the routes of a session in the network namespace ns. ifindex and mark tell whether their control messages carry
+//the interface and the mark, or the socket is bound to them
+func newRoutes(ns string, ifindex, mark bool) *routes {
+	return &routes{known: make(map[binding]routed), ns: ns, ifindex: ifindex, mark: mark}
+}
+
+//lookup returns the route of the requests with the Config cfg and its control messages
+func (rs *routes) lookup(cfg goping.Config) (route, []byte, error) {
+	b, err := bindingOf(cfg)
+	if err != nil || b.empty() {
+		return route{}, nil, err
+	}
+	r, ok := rs.known[b]
+	if !ok {
+		if r.rt, r.err = b.check(rs.ns); r.err == nil {
+			r.oob = r.rt.control(rs.ifindex, rs.mark)
+		}
+		rs.known[b] = r
+	}
+	return r.rt, r.oob, r.err
+}
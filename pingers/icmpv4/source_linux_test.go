@@ -0,0 +1,166 @@
+package icmpv4
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/gracig/goping"
+	"golang.org/x/sys/unix"
+)
+
+func TestBindingCheck(t *testing.T) {
+	tt := []struct {
+		name    string
+		cfg     goping.Config
+		ifindex int
+		err     error
+	}{
+		{name: "loopback", cfg: goping.Config{Source: net.IPv4(127, 0, 0, 2)}},
+		{name: "loopback on lo", cfg: goping.Config{Source: net.IPv4(127, 0, 0, 2), Interface: "lo"}, ifindex: 1},
+		{name: "lo", cfg: goping.Config{Interface: "lo"}, ifindex: 1},
+		//TEST-NET-2 is not assigned to the hosts that run the tests
+		{name: "not local", cfg: goping.Config{Source: net.IPv4(198, 51, 100, 77)}, err: goping.ErrSourceNotLocal},
+		{name: "not local on lo", cfg: goping.Config{Source: net.IPv4(198, 51, 100, 77), Interface: "lo"}, err: goping.ErrSourceNotLocal},
+		{name: "IPv6", cfg: goping.Config{Source: net.ParseIP("::1")}, err: goping.ErrSourceNotLocal},
+		{name: "no interface", cfg: goping.Config{Interface: "nosuchif0"}, err: goping.ErrNoSuchInterface},
+	}
+	for _, tc := range tt {
+		b, err := bindingOf(tc.cfg)
+		var rt route
+		if err == nil {
+			rt, err = b.check("")
+		}
+		if !errors.Is(err, tc.err) || (err == nil && rt.ifindex != tc.ifindex) {
+			t.Errorf("No match for %v. Expected: [%v %v], Got: [%v %v]", tc.name, tc.ifindex, tc.err, rt.ifindex, err)
+		}
+	}
+}
+
+func TestRouteControl(t *testing.T) {
+	rt := route{binding: binding{src: [4]byte{10, 0, 0, 1}, iface: "eth1", mark: 7}, ifindex: 3}
+	tt := []struct {
+		name          string
+		rt            route
+		ifindex, mark bool
+		pktinfo       *syscall.Inet4Pktinfo
+		soMark        int
+	}{
+		{"raw", rt, true, true, &syscall.Inet4Pktinfo{Ifindex: 3, Spec_dst: [4]byte{10, 0, 0, 1}}, 7},
+		//The socket is bound to the interface and the mark
+		{"bound", rt, false, false, &syscall.Inet4Pktinfo{Spec_dst: [4]byte{10, 0, 0, 1}}, 0},
+		{"mark only", route{binding: binding{mark: 7}}, true, true, nil, 7},
+		{"interface only", route{binding: binding{iface: "eth1"}, ifindex: 3}, true, true, &syscall.Inet4Pktinfo{Ifindex: 3}, 0},
+		{"interface bound", route{binding: binding{iface: "eth1"}, ifindex: 3}, false, false, nil, 0},
+	}
+	for _, tc := range tt {
+		cmsgs, err := syscall.ParseSocketControlMessage(tc.rt.control(tc.ifindex, tc.mark))
+		if err != nil {
+			t.Errorf("Error not expected for %v: %v", tc.name, err)
+			continue
+		}
+		var pktinfo *syscall.Inet4Pktinfo
+		var mark int
+		for _, m := range cmsgs {
+			switch {
+			case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO:
+				pktinfo = (*syscall.Inet4Pktinfo)(unsafe.Pointer(&m.Data[0]))
+			case m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == unix.SO_MARK:
+				mark = int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+			}
+		}
+		if (pktinfo == nil) != (tc.pktinfo == nil) || (pktinfo != nil && *pktinfo != *tc.pktinfo) || mark != tc.soMark {
+			t.Errorf("No match for %v. Expected: [%v %v], Got: [%v %v]", tc.name, tc.pktinfo, tc.soMark, pktinfo, mark)
+		}
+	}
+}
+
+func TestSourceLoopback(t *testing.T) {
+	p := New()
+	rawAvailable(t, p)
+	//The session pings from 127.0.0.2, through lo, with a mark. A request may choose another source
+	cfg := goping.Config{Count: 2, Interval: 10 * time.Millisecond, Timeout: time.Second, TTL: 64, Source: net.IPv4(127, 0, 0, 2), Interface: "lo", Mark: 7}
+	g := goping.New(cfg, p, nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	go func() {
+		ping <- g.NewRequest("127.0.0.1", map[string]string{"src": "127.0.0.2"})
+		r := g.NewRequest("127.0.0.1", map[string]string{"src": "198.51.100.77"})
+		r.Config.Source = net.IPv4(198, 51, 100, 77)
+		ping <- r
+		close(ping)
+	}()
+	var replies, refused int
+	for r := range pong {
+		switch r.Request.UserData["src"] {
+		case "127.0.0.2":
+			//The reply is sent to the source of the request
+			if r.Err != nil || len(r.ICMPMessage) < ipHeaderLen || !net.IP(r.ICMPMessage[16:20]).Equal(cfg.Source) {
+				t.Errorf("Unexpected reply: [%v %x]", r.Err, r.ICMPMessage)
+				continue
+			}
+			replies++
+		default:
+			if !errors.Is(r.Err, goping.ErrSourceNotLocal) {
+				t.Errorf("Error Expected: %v Got: %v", goping.ErrSourceNotLocal, r.Err)
+				continue
+			}
+			refused++
+		}
+	}
+	if replies != cfg.Count || refused != cfg.Count {
+		t.Errorf("No match responses. Expected: [%v %v], Got: [%v %v]", cfg.Count, cfg.Count, replies, refused)
+	}
+}
+
+//refusingSyscall fails every send with err
+type refusingSyscall struct {
+	syscallWrapper
+	err error
+}
+
+func (s refusingSyscall) Sendmsg(fd int, p, oob []byte, to syscall.Sockaddr, flags int) error {
+	return s.err
+}
+
+func (s refusingSyscall) Sendmmsg(fd int, msgs []mmsghdr, flags int) (int, error) {
+	return 0, s.err
+}
+
+func TestSendMarkRefused(t *testing.T) {
+	marked := route{binding: binding{mark: 7}}.control(true, true)
+	unmarked := route{binding: binding{iface: "eth1"}, ifindex: 3}.control(true, true)
+	tt := []struct {
+		name  string
+		batch int
+		err   error
+		oobs  [][]byte
+		match []error
+	}{
+		{"marked", 1, syscall.EINVAL, [][]byte{marked}, []error{goping.ErrMarkNotSupported}},
+		{"marked in a batch", 2, syscall.EINVAL, [][]byte{unmarked, marked}, []error{nil, goping.ErrMarkNotSupported}},
+		//Only the marked packets refused as invalid are explained by the mark
+		{"not marked", 1, syscall.EINVAL, [][]byte{unmarked}, []error{nil}},
+		{"other error", 1, syscall.EPERM, [][]byte{marked}, []error{nil}},
+	}
+	for _, tc := range tt {
+		p := New().(*pinger)
+		p.syscall = refusingSyscall{err: tc.err}
+		b := newBatch(tc.batch, 0, 0)
+		for i, oob := range tc.oobs {
+			b.set(i, make([]byte, 28), [4]byte{10, 0, 0, 1}, oob)
+		}
+		errs := make([]error, len(tc.oobs))
+		p.send(-1, b, len(tc.oobs), func(i int, err error) { errs[i] = err })
+		for i, err := range errs {
+			if err == nil || errors.Is(err, goping.ErrMarkNotSupported) != (tc.match[i] != nil) {
+				t.Errorf("No match error %v of %v. Expected: [%v], Got: [%v]", i, tc.name, tc.match[i], err)
+			}
+		}
+	}
+}
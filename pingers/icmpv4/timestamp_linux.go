@@ -0,0 +1,164 @@
+package icmpv4
+
+import (
+	"encoding/binary"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/gracig/goping"
+	"golang.org/x/sys/unix"
+)
+
+/*** Send timestamps ***/
+
+//timestamping asks the kernel for the software and hardware timestamps of the sent packets, queued in the error queue of the socket
+//with a copy of the packet, and for the hardware timestamps of the received packets. Hardware timestamps need the network card to be
+//configured to take them
+const timestamping = unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_TX_HARDWARE | unix.SOF_TIMESTAMPING_RX_HARDWARE |
+	unix.SOF_TIMESTAMPING_SOFTWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+
+//stampAge is how long the send time of a request is kept for its replies
+const stampAge = time.Minute
+
+//probe identifies an echo request by its destination, its identifier and its sequence
+type probe struct {
+	dst     [4]byte
+	id, seq uint16
+}
+
+//stamp holds the send times of a request
+type stamp struct {
+	mono       time.Time       //Read by the sender with the monotonic clock, just before sending
+	echo       syscall.Timeval //The timestamp written in the echo data, read with mono
+	soft, hard time.Time       //The kernel timestamps. Zero when the kernel did not take them
+	flow       bool            //The echo data carries the flow word of Config.FlowID
+}
+
+//stamps are the send times of the requests of a socket. The senders keep them, and the receiver adds the kernel timestamps
+//read from the error queue of the socket
+type stamps struct {
+	mu    sync.Mutex
+	sent  map[probe]stamp
+	swept time.Time
+	buf   []byte
+	oob   []byte
+}
+
+func newStamps() *stamps {
+	return &stamps{sent: make(map[probe]stamp), swept: time.Now(), buf: make([]byte, 65536), oob: make([]byte, 256)}
+}
+
+//keep records the send time now of the request pr, and whether its echo data carries a flow word. A request sent again with the same probe replaces the old one
+func (s *stamps) keep(pr probe, now time.Time, flow bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent[pr] = stamp{mono: now, echo: syscall.NsecToTimeval(now.UnixNano()), flow: flow}
+}
+
+//lookup returns the send times of the request pr, and false when they are not kept
+func (s *stamps) lookup(pr probe) (stamp, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.sent[pr]
+	return st, ok
+}
+
+//drain reads the kernel timestamps waiting in the error queue of the socket fd. Only the receiver of the socket reads its error queue
+func (s *stamps) drain(sys syscallWrapperInterface, fd int) {
+	for {
+		n, oobn, _, _, err := sys.Recvmsg(fd, s.buf, s.oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		if err != nil {
+			break
+		}
+		pr, ok := sentProbe(s.buf[:n])
+		if !ok {
+			continue
+		}
+		soft, hard := kernelStamps(s.oob[:oobn])
+		//The software and hardware timestamps of a packet are queued apart
+		s.mu.Lock()
+		if st, ok := s.sent[pr]; ok {
+			if !soft.IsZero() {
+				st.soft = soft
+			}
+			if !hard.IsZero() {
+				st.hard = hard
+			}
+			s.sent[pr] = st
+		}
+		s.mu.Unlock()
+	}
+}
+
+//sweep forgets the requests whose replies are not expected anymore, every stampAge
+func (s *stamps) sweep(now time.Time) {
+	if now.Sub(s.swept) < stampAge {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for pr, st := range s.sent {
+		if now.Sub(st.mono) > stampAge {
+			delete(s.sent, pr)
+		}
+	}
+	s.swept = now
+}
+
+//measure returns the RTT of the reply to the request sent at st, and the clocks that timestamped them.
+//now is the time the reply was read, with the monotonic clock. rx and rxHard are the kernel timestamps of the reply, zero when missing.
+//The RTT is measured by the monotonic clock, which is not stepped with the time of the system. The kernel timestamps are more precise,
+//but read the time of the system. When the request and the reply have them, they are used if their RTT fits inside the monotonic one,
+//as it must unless the time was stepped. Hardware timestamps are only compared with each other, the clock of the network card is not
+//the clock of the system
+func measure(st stamp, now, rx, rxHard time.Time) (rtt time.Duration, send, recv goping.StampSource) {
+	rtt = now.Sub(st.mono)
+	send, recv = goping.StampUser, goping.StampUser
+	fits := func(d time.Duration) bool { return d >= 0 && d <= rtt }
+	switch {
+	case !st.hard.IsZero() && !rxHard.IsZero() && fits(rxHard.Sub(st.hard)):
+		return rxHard.Sub(st.hard), goping.StampHardware, goping.StampHardware
+	case !st.soft.IsZero() && !rx.IsZero() && fits(rx.Sub(st.soft)):
+		return rx.Sub(st.soft), goping.StampKernel, goping.StampKernel
+	}
+	return
+}
+
+//sentProbe finds the echo request inside the copy of a sent packet. The copy starts with the link layer header, whose size depends on the interface
+func sentProbe(buf []byte) (pr probe, ok bool) {
+	for l := 0; l+ipHeaderLen+8 <= len(buf) && l <= 64; l++ {
+		ip := buf[l:]
+		if ip[0] != 0x45 || ip[9] != syscall.IPPROTO_ICMP || int(binary.BigEndian.Uint16(ip[2:])) != len(ip) || ip[ipHeaderLen] != 8 {
+			continue
+		}
+		copy(pr.dst[:], ip[16:20])
+		pr.id = binary.BigEndian.Uint16(ip[ipHeaderLen+4:])
+		pr.seq = binary.BigEndian.Uint16(ip[ipHeaderLen+6:])
+		return pr, true
+	}
+	return
+}
+
+//kernelStamps returns the software and the hardware timestamps of the SCM_TIMESTAMPING control message in oob. Zero when missing
+func kernelStamps(oob []byte) (soft, hard time.Time) {
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, m := range cmsgs {
+		if m.Header.Level != syscall.SOL_SOCKET || m.Header.Type != unix.SCM_TIMESTAMPING || len(m.Data) < int(unsafe.Sizeof(unix.ScmTimestamping{})) {
+			continue
+		}
+		//The first timestamp is the software one, the third the raw hardware one
+		ts := (*unix.ScmTimestamping)(unsafe.Pointer(&m.Data[0]))
+		if ts.Ts[0].Sec != 0 || ts.Ts[0].Nsec != 0 {
+			soft = time.Unix(ts.Ts[0].Unix())
+		}
+		if ts.Ts[2].Sec != 0 || ts.Ts[2].Nsec != 0 {
+			hard = time.Unix(ts.Ts[2].Unix())
+		}
+	}
+	return
+}
@@ -0,0 +1,251 @@
+package icmpv4
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/gracig/goping"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+func TestSentProbe(t *testing.T) {
+	echo := packet(t, icmp.Message{Type: ipv4.ICMPTypeEcho, Body: &icmp.Echo{ID: 0x1234, Seq: 7, Data: make([]byte, 16)}})
+	want := probe{dst: [4]byte{10, 0, 0, 2}, id: 0x1234, seq: 7}
+	//No link layer header, ethernet, and ethernet with a VLAN tag
+	for _, l := range []int{0, 14, 18} {
+		buf := append(make([]byte, l), echo...)
+		if l >= 2 {
+			buf[l-2], buf[l-1] = 0x08, 0x00
+		}
+		if pr, ok := sentProbe(buf); !ok || pr != want {
+			t.Errorf("No match probe after %v bytes. Expected: [%v], Got: [%v %v]", l, want, pr, ok)
+		}
+	}
+	reply := packet(t, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: 7, Data: make([]byte, 16)}})
+	if _, ok := sentProbe(append(make([]byte, 14), reply...)); ok {
+		t.Errorf("Found a probe in an echo reply")
+	}
+	if _, ok := sentProbe(append(make([]byte, 14), echo[:30]...)); ok {
+		t.Errorf("Found a probe in a truncated packet")
+	}
+}
+
+func TestKernelStamps(t *testing.T) {
+	soft, hard := time.Unix(1700000000, 123456789), time.Unix(5000, 42)
+	var ts unix.ScmTimestamping
+	ts.Ts[0] = unix.NsecToTimespec(soft.UnixNano())
+	ts.Ts[2] = unix.NsecToTimespec(hard.UnixNano())
+	size := int(unsafe.Sizeof(ts))
+	oob := make([]byte, syscall.CmsgSpace(size))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level, h.Type = syscall.SOL_SOCKET, unix.SCM_TIMESTAMPING
+	h.SetLen(syscall.CmsgLen(size))
+	copy(oob[syscall.CmsgLen(0):], (*[unsafe.Sizeof(ts)]byte)(unsafe.Pointer(&ts))[:])
+	if s, h := kernelStamps(oob); !s.Equal(soft) || !h.Equal(hard) {
+		t.Errorf("No match timestamps. Expected: [%v %v], Got: [%v %v]", soft, hard, s, h)
+	}
+	//Without hardware timestamp
+	ts.Ts[2] = unix.Timespec{}
+	copy(oob[syscall.CmsgLen(0):], (*[unsafe.Sizeof(ts)]byte)(unsafe.Pointer(&ts))[:])
+	if s, h := kernelStamps(oob); !s.Equal(soft) || !h.IsZero() {
+		t.Errorf("No match timestamps. Expected: [%v zero], Got: [%v %v]", soft, s, h)
+	}
+}
+
+func TestSendStamps(t *testing.T) {
+	p := New()
+	rawAvailable(t, p)
+	ping, pong, done, err := p.Start(0x4343)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	req := goping.Request{Host: "127.0.0.1", Config: goping.Config{TTL: 64}}
+	for seq := 0; seq < 5; seq++ {
+		ping <- goping.SeqRequest{Seq: seq, Req: req, Dst: net.IPv4(127, 0, 0, 1), ID: 0x4343}
+		select {
+		case r := <-pong:
+			//The loopback does not take hardware timestamps
+			if r.Err != nil || r.SendStamp != goping.StampKernel || r.RecvStamp != goping.StampKernel || r.RTT < 0 || r.RTT > 100 {
+				t.Errorf("Unexpected response: [%v %v %v %v]", r.Err, r.SendStamp, r.RecvStamp, r.RTT)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("No reply to %v", seq)
+		}
+	}
+	close(ping)
+	<-done
+}
+
+func TestMeasure(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	us := time.Microsecond
+	//The request was sent at base and its reply read 100us later
+	now := base.Add(100 * us)
+	soft, hard := base.Add(10*us), time.Unix(5000, 0)
+	tt := []struct {
+		name       string
+		st         stamp
+		rx, rxHard time.Time
+		rtt        time.Duration
+		send, recv goping.StampSource
+	}{
+		{"no kernel timestamps", stamp{mono: base}, time.Time{}, time.Time{}, 100 * us, goping.StampUser, goping.StampUser},
+		{"kernel", stamp{mono: base, soft: soft}, soft.Add(50 * us), time.Time{}, 50 * us, goping.StampKernel, goping.StampKernel},
+		{"hardware", stamp{mono: base, soft: soft, hard: hard}, soft.Add(50 * us), hard.Add(40 * us), 40 * us, goping.StampHardware, goping.StampHardware},
+		{"hardware reply only", stamp{mono: base, soft: soft}, soft.Add(50 * us), hard.Add(40 * us), 50 * us, goping.StampKernel, goping.StampKernel},
+		{"kernel request only", stamp{mono: base, soft: soft}, time.Time{}, time.Time{}, 100 * us, goping.StampUser, goping.StampUser},
+		//The time of the system was stepped between the request and the reply
+		{"stepped forward", stamp{mono: base, soft: soft}, soft.Add(time.Hour), time.Time{}, 100 * us, goping.StampUser, goping.StampUser},
+		{"stepped back", stamp{mono: base, soft: soft}, soft.Add(-time.Hour), time.Time{}, 100 * us, goping.StampUser, goping.StampUser},
+		{"hardware out of range", stamp{mono: base, soft: soft, hard: hard}, soft.Add(50 * us), hard.Add(time.Second), 50 * us, goping.StampKernel, goping.StampKernel},
+	}
+	for _, tc := range tt {
+		rtt, send, recv := measure(tc.st, now, tc.rx, tc.rxHard)
+		if rtt != tc.rtt || send != tc.send || recv != tc.recv {
+			t.Errorf("No match for %v. Expected: [%v %v %v], Got: [%v %v %v]", tc.name, tc.rtt, tc.send, tc.recv, rtt, send, recv)
+		}
+	}
+}
+
+func TestStampMismatch(t *testing.T) {
+	p := New().(*pinger)
+	out := make(chan goping.RawResponse)
+	p.mux.owners[0x1234] = out
+	sent := newStamps()
+	cfg := goping.Config{PacketSize: 56}
+	sentAt := time.Now()
+	sent.keep(probe{dst: [4]byte{10, 0, 0, 1}, id: 0x1234, seq: 7}, sentAt, false)
+	from := [4]byte{10, 0, 0, 1}
+	echoed := func(seq int, at time.Time) []byte {
+		data := p.payload(cfg, syscall.NsecToTimeval(at.UnixNano()))
+		return packet(t, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: seq, Data: data}})
+	}
+	tt := []struct {
+		name     string
+		buf      []byte
+		mismatch bool
+		rtt      func(float64) bool
+	}{
+		{"echoed as sent", echoed(7, sentAt), false, func(rtt float64) bool { return rtt >= 0 && rtt < 1000 }},
+		//The RTT of the host that echoes another timestamp is measured from the send time kept
+		{"echoed an hour later", echoed(7, sentAt.Add(time.Hour)), true, func(rtt float64) bool { return rtt >= 0 && rtt < 1000 }},
+		//Without the send time, the echoed timestamp is trusted
+		{"not kept", echoed(8, sentAt.Add(-time.Hour)), false, func(rtt float64) bool { return rtt >= 3600*1000 }},
+	}
+	for _, tc := range tt {
+		got, resp, ok := p.reply(tc.buf, nil, from, 0, sent, time.Now())
+		if !ok || got == nil {
+			t.Fatalf("Reply %v not accepted", tc.name)
+		}
+		if resp.StampMismatch != tc.mismatch || !tc.rtt(resp.RTT) {
+			t.Errorf("No match for %v. Expected mismatch: [%v], Got: [%v %v]", tc.name, tc.mismatch, resp.StampMismatch, resp.RTT)
+		}
+	}
+}
+
+func TestDgramStampMismatch(t *testing.T) {
+	p := NewUnprivileged().(*dgramPinger)
+	cfg := goping.Config{PacketSize: 56}
+	//The receiver reads the replies from a UDP socket, sent from another one on the loopback
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Usec: 100000})
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	to, _ := syscall.Getsockname(fd)
+	sender, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	defer syscall.Close(sender)
+
+	sent := newStamps()
+	sentAt := time.Now()
+	sent.keep(probe{dst: [4]byte{127, 0, 0, 1}, id: 0x1234, seq: 7}, sentAt, false)
+	out, stop, stopped := make(chan goping.RawResponse), make(chan struct{}), make(chan struct{})
+	go func() {
+		p.pong(0x4444, 0x1234, fd, sent, out, stop)
+		close(stopped)
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	echoed := func(seq int, at time.Time) []byte {
+		data := p.payload(cfg, syscall.NsecToTimeval(at.UnixNano()))
+		b, err := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: seq, Data: data}}).Marshal(nil)
+		if err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		return b
+	}
+	tt := []struct {
+		name     string
+		buf      []byte
+		mismatch bool
+		send     goping.StampSource
+		rtt      func(float64) bool
+	}{
+		{"echoed as sent", echoed(7, sentAt), false, goping.StampUser, func(rtt float64) bool { return rtt >= 0 && rtt < 1000 }},
+		//The RTT of the host that echoes another timestamp is measured from the send time kept
+		{"echoed an hour later", echoed(7, sentAt.Add(time.Hour)), true, goping.StampUser, func(rtt float64) bool { return rtt >= 0 && rtt < 1000 }},
+		//Without the send time, the echoed timestamp is trusted
+		{"not kept", echoed(8, sentAt.Add(-time.Hour)), false, goping.StampUser, func(rtt float64) bool { return rtt >= 3600*1000 }},
+	}
+	for _, tc := range tt {
+		if err := syscall.Sendto(sender, tc.buf, 0, to); err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		select {
+		case resp := <-out:
+			if resp.ID != 0x4444 || resp.StampMismatch != tc.mismatch || resp.SendStamp != tc.send || !tc.rtt(resp.RTT) {
+				t.Errorf("No match for %v. Expected: [%v %v], Got: [%v %v %v %v]", tc.name, tc.mismatch, tc.send, resp.ID, resp.StampMismatch, resp.SendStamp, resp.RTT)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Reply %v not accepted", tc.name)
+		}
+	}
+}
+
+func TestReplyFlowWord(t *testing.T) {
+	p := New().(*pinger)
+	p.mux.owners[0x1234] = make(chan goping.RawResponse)
+	from := [4]byte{10, 0, 0, 1}
+	sentAt := time.Now()
+	//reply builds the echo reply of the request with cfg, with the word after the timestamp changed to word
+	reply := func(cfg goping.Config, seq int, word uint16) []byte {
+		data := p.payload(cfg, syscall.NsecToTimeval(sentAt.UnixNano()))
+		buf := packet(t, icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 0x1234, Seq: seq, Data: data}})
+		flowChecksum(buf[ipHeaderLen:], 8+timestampSize, word)
+		return buf
+	}
+	flow, plain := goping.Config{PacketSize: 56, FlowID: 3}, goping.Config{PacketSize: 56}
+	sent := newStamps()
+	sent.keep(probe{dst: from, id: 0x1234, seq: 1}, sentAt, true)
+	sent.keep(probe{dst: from, id: 0x1234, seq: 2}, sentAt, false)
+	tt := []struct {
+		name string
+		buf  []byte
+		err  error
+	}{
+		{"flow word", reply(flow, 1, 3), nil},
+		//The checksum stays valid, only the data changed
+		{"corrupted without flow", reply(plain, 2, 0xbeef), goping.ErrCorruptPayload},
+		//Without the send times the request may have had a flow word
+		{"not kept", reply(plain, 3, 0xbeef), nil},
+	}
+	for _, tc := range tt {
+		_, resp, ok := p.reply(tc.buf, nil, from, 0, sent, time.Now())
+		if !ok || resp.Err != tc.err {
+			t.Errorf("No match for %v. Expected: [true %v], Got: [%v %v]", tc.name, tc.err, ok, resp.Err)
+		}
+	}
+}
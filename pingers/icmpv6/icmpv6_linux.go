@@ -0,0 +1,297 @@
+package icmpv6
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/gracig/goping"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+//Offsets of the fields inside the ICMPv6 messages. Raw ICMPv6 sockets do not receive the IPv6 header
+const (
+	//Echo Reply
+	echoID   = 4
+	echoSeq  = 6
+	echoData = 8
+	//Error messages quote the original IPv6 header after the 8 bytes of the ICMPv6 header
+	quotedNextHeader = 8 + 6
+	quotedDst        = 8 + 24
+	quotedICMP       = 8 + 40
+	quotedID         = quotedICMP + echoID
+	quotedSeq        = quotedICMP + echoSeq
+	quotedData       = quotedICMP + echoData
+)
+
+//New returns a new Pinger
+func New() goping.Pinger {
+	return &pinger{}
+}
+
+//Pinger is the type the implements goping.Pinger interface
+type pinger struct{}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+
+	//Initialize the channels used in the select stage
+	input, output, doneInput, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{}), make(chan struct{})
+
+	//Opens the connection
+	fd, lerr := p.OpenConn()
+	if lerr != nil {
+		//Returns error that connection could not be opened
+		err = fmt.Errorf("Connection could not be opened: %v", lerr)
+		return
+	}
+	//The identifiers of the pings sent by the session
+	ids := new(goping.IdentSet)
+	//Start Sending ICMPv6 Requests to the File Descriptor
+	go p.ping(ids, fd, input, output, doneInput)
+	//Start receiving ICMPv6 Replies from the File Descriptor
+	go p.pong(ids, fd, output, doneInput, doneOutput)
+
+	ping, pong, done = input, output, doneOutput
+	return
+}
+
+//OpenConn opens a raw ICMPv6 socket fd
+func (p pinger) OpenConn() (int, error) {
+	//Create a raw socket to read icmpv6 packets. The kernel computes the ICMPv6 checksum
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return 0, err
+	}
+	//Closes the socket if it could not be configured
+	fail := func(err error) (int, error) {
+		syscall.Close(fd)
+		return 0, err
+	}
+	//Only receive echo replies and the error messages
+	var filter syscall.ICMPv6Filter
+	for i := range filter.Data {
+		filter.Data[i] = math.MaxUint32
+	}
+	for _, t := range []ipv6.ICMPType{
+		ipv6.ICMPTypeEchoReply,
+		ipv6.ICMPTypeDestinationUnreachable,
+		ipv6.ICMPTypePacketTooBig,
+		ipv6.ICMPTypeTimeExceeded,
+		ipv6.ICMPTypeParameterProblem,
+	} {
+		filter.Data[t>>5] &^= 1 << (uint32(t) & 31)
+	}
+	if err := syscall.SetsockoptICMPv6Filter(fd, syscall.SOL_ICMPV6, syscall.ICMPV6_FILTER, &filter); err != nil {
+		return fail(err)
+	}
+	//Set the option to receive the kernel timestamp from each received message
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1); err != nil {
+		return fail(err)
+	}
+	//Increase the socket buffer
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, 1024*1024); err != nil {
+		return fail(err)
+	}
+	//Set timeout while reading from socket
+	tv := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return fail(err)
+	}
+	//Listen on all interfaces
+	if err := syscall.Bind(fd, &syscall.SockaddrInet6{}); err != nil {
+		return fail(err)
+	}
+	return fd, nil
+}
+
+//Resolve is the implementation of the method goping.Resolver.Resolve
+func (p pinger) Resolve(host string) (net.IP, error) {
+	addr, err := net.ResolveIPAddr("ip6", host)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP.To16(), nil
+}
+
+//hopOptions builds the control messages that set the hop limit and traffic class of a single packet.
+//Values lower than 1 for the hop limit and lower than 0 for the traffic class use the kernel defaults
+func hopOptions(hopLimit, tclass int) []byte {
+	if hopLimit < 1 {
+		hopLimit = -1
+	}
+	if tclass < 0 {
+		tclass = -1
+	}
+	oob := make([]byte, 0, 2*syscall.CmsgSpace(4))
+	for _, opt := range []struct{ typ, value int }{
+		{syscall.IPV6_HOPLIMIT, hopLimit},
+		{syscall.IPV6_TCLASS, tclass},
+	} {
+		b := make([]byte, syscall.CmsgSpace(4))
+		h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+		h.Level = syscall.IPPROTO_IPV6
+		h.Type = int32(opt.typ)
+		h.SetLen(syscall.CmsgLen(4))
+		binary.LittleEndian.PutUint32(b[syscall.CmsgLen(0):], uint32(opt.value))
+		oob = append(oob, b...)
+	}
+	return oob
+}
+
+func (p pinger) ping(ids *goping.IdentSet, fd int, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var echo icmp.Echo
+	var icmpmsg = icmp.Message{
+		Type: ipv6.ICMPTypeEchoRequest,
+		Code: 0,
+	}
+	var ip net.IP
+	var icmpb []byte
+	var buffer bytes.Buffer
+	var tv syscall.Timeval
+
+	var address = make(map[string]net.IP)
+	var addressError = make(map[string]error)
+
+	for r := range in {
+		//Resolve HostName, when the session did not
+		var err error
+		if ip = r.Dst.To16(); ip == nil {
+			if _, ok := address[r.Req.Host]; !ok {
+				if addr, lerr := p.Resolve(r.Req.Host); lerr != nil {
+					addressError[r.Req.Host] = lerr
+					address[r.Req.Host] = net.IPv6unspecified
+				} else {
+					address[r.Req.Host] = addr
+				}
+			}
+			if addressError[r.Req.Host] != nil {
+				out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+				continue
+			}
+			ip = address[r.Req.Host]
+		}
+		//Create the target address to use in the Sendmsg socket method
+		var to syscall.SockaddrInet6
+		copy(to.Addr[:], ip)
+
+		//Built the Data to be send
+		buffer.Reset()
+		syscall.Gettimeofday(&tv)
+		binary.Write(&buffer, binary.LittleEndian, &tv)
+		echo.Data = buffer.Bytes()
+		//Get the ICMP echo Identifier. Its replies are accepted by pong
+		echo.ID = r.ID
+		ids.Add(r.ID)
+		//Get the ICMP echo sequence number
+		echo.Seq = r.Seq
+		//Build the ICMP echo body
+		icmpmsg.Body = &echo
+		//Build bytes of the ICMPv6 echo. The checksum is filled by the kernel
+		if icmpb, err = icmpmsg.Marshal(nil); err != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not marshall ICMP Echo"), RTT: math.NaN()}
+			continue
+		}
+		//Sending the packet through the network with the TTL and TOS mapped to hop limit and traffic class
+		if err = syscall.Sendmsg(fd, icmpb, hopOptions(r.Req.Config.TTL, r.Req.Config.TOS), &to, 0); err != nil {
+			out <- goping.RawResponse{Seq: r.Seq, ID: r.ID, Dst: ip, Err: errors.New("Could not Send Ping over the socket"), RTT: math.NaN()}
+			continue
+		}
+	}
+	go func() {
+		done <- struct{}{}
+	}()
+}
+
+func (p pinger) pong(ids *goping.IdentSet, fd int, out chan<- goping.RawResponse, donein <-chan struct{}, done chan<- struct{}) {
+	//Buffer to receive the ping packet
+	buf := make([]byte, 1500)
+	//Buffer to receive the control message
+	oob := make([]byte, 64)
+	//Size of the timestamp sent in the echo data
+	tvsz := binary.Size(syscall.Timeval{})
+	//Infinite loop to wait for messages
+	for {
+		//If doneIn is closed then close done channel and exit function
+		select {
+		case <-donein:
+			close(done)
+			if err := syscall.Close(fd); err != nil {
+				fmt.Printf("Error calling syscall.Close %v\n", err)
+			}
+			return
+		default:
+		}
+
+		//Receives a message from the socket sent by the kernel
+		n, oobn, _, from, err := syscall.Recvmsg(fd, buf, oob, 0)
+		if err != nil || n < echoData {
+			continue
+		}
+		var endTime = time.Now()
+
+		//Get peer address. It is the router that sent an error message
+		peer := make(net.IP, net.IPv6len)
+		copy(peer, from.(*syscall.SockaddrInet6).Addr[:])
+
+		//Finds the pid, seq, destination, the position of the sent timestamp and the error of the message
+		var pid, seq, data int
+		var perr error
+		dst := make(net.IP, net.IPv6len)
+		typ := ipv6.ICMPType(buf[0])
+		switch typ {
+		case ipv6.ICMPTypeEchoReply:
+			pid = int(binary.BigEndian.Uint16(buf[echoID:]))
+			seq = int(binary.BigEndian.Uint16(buf[echoSeq:]))
+			copy(dst, peer)
+			data = echoData
+		default:
+			//Only error messages quoting one of our echo requests are accepted. Error types have the high bit clear
+			if typ >= 128 || n < quotedData ||
+				buf[quotedNextHeader] != syscall.IPPROTO_ICMPV6 || ipv6.ICMPType(buf[quotedICMP]) != ipv6.ICMPTypeEchoRequest {
+				continue
+			}
+			pid = int(binary.BigEndian.Uint16(buf[quotedID:]))
+			seq = int(binary.BigEndian.Uint16(buf[quotedSeq:]))
+			copy(dst, buf[quotedDst:quotedDst+net.IPv6len])
+			data = quotedData
+			perr, _ = goping.ParseICMPError(goping.ProtocolIPv6ICMP, peer, buf[:n])
+		}
+		if !ids.Has(pid) {
+			continue
+		}
+		var recvStamp = goping.StampUser
+		//Parses the Control Message to find the SO_TIMESTAMP value
+		if cmsgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, m := range cmsgs {
+				if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_TIMESTAMP {
+					var tv syscall.Timeval
+					binary.Read(bytes.NewReader(m.Data), binary.LittleEndian, &tv)
+					endTime, recvStamp = time.Unix(tv.Unix()), goping.StampKernel
+				}
+			}
+		}
+		//Computes the RTT from the timestamp sent in the echo data. The RTT of an error is the time until the router answered
+		var rtt = math.NaN()
+		if n >= data+tvsz {
+			var tv syscall.Timeval
+			binary.Read(bytes.NewReader(buf[data:data+tvsz]), binary.LittleEndian, &tv)
+			rtt = float64(endTime.Sub(time.Unix(tv.Unix())).Nanoseconds()) / 1e6
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+
+		//GoRoutine that sends the raw response to channel out
+		go func(pid, seq int, msg []byte, peer, dst net.IP, rtt float64, stamp goping.StampSource, err error) {
+			out <- goping.RawResponse{Seq: seq, ID: pid, ICMPMessage: msg, Peer: peer, Dst: dst, RTT: rtt, RecvStamp: stamp, Err: err}
+		}(pid, seq, msg, peer, dst, rtt, recvStamp, perr)
+	}
+}
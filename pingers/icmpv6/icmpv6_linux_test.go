@@ -0,0 +1,43 @@
+package icmpv6
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+func TestPingLoopback(t *testing.T) {
+	if _, err := New().(*pinger).OpenConn(); err != nil {
+		t.Skipf("Raw ICMPv6 socket not available: %v", err)
+	}
+	cfg := goping.Config{Count: 3, Interval: 10 * time.Millisecond, Timeout: time.Second, TTL: 64}
+	g := goping.New(cfg, New(), nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	go func() {
+		ping <- g.NewRequest("::1", nil)
+		close(ping)
+	}()
+	var received int
+	for r := range pong {
+		received++
+		if r.Err != nil {
+			t.Errorf("Error not expected: %v", r.Err)
+			continue
+		}
+		if !r.Peer.Equal(net.IPv6loopback) {
+			t.Errorf("No match Peer. Expected: [%v], Got: [%v]", net.IPv6loopback, r.Peer)
+		}
+		if math.IsNaN(r.RTT) || r.RTT < 0 {
+			t.Errorf("Invalid RTT: %v", r.RTT)
+		}
+	}
+	if received != cfg.Count {
+		t.Errorf("No match responses. Expected: [%v], Got: [%v]", cfg.Count, received)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package icmpv6
+
+import (
+	"errors"
+
+	"github.com/gracig/goping"
+)
+
+//New returns a new Pinger
+func New() goping.Pinger {
+	return &pinger{}
+}
+
+//Pinger is the type the implements goping.Pinger interface
+type pinger struct{}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+	err = errors.New("icmpv6 pinger is only implemented on linux")
+	return
+}
@@ -0,0 +1,19 @@
+//Package netns opens the sockets of the pingers inside network namespaces
+package netns
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+//Dir is the directory of the network namespaces named by ip netns
+const Dir = "/var/run/netns"
+
+//Path returns the path of the network namespace ns: ns itself when it is a path, or the namespace named ns by ip netns.
+//Empty is the namespace of the process
+func Path(ns string) string {
+	if ns == "" || strings.ContainsRune(ns, '/') {
+		return ns
+	}
+	return filepath.Join(Dir, ns)
+}
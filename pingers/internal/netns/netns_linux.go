@@ -0,0 +1,34 @@
+package netns
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+//Do runs f inside the network namespace ns, named by ip netns or given by its path. Sockets opened by f stay in the namespace
+//after f returns. f runs in a thread of its own that is never given back to the other goroutines, so the rest of the process
+//stays in its namespace. An empty ns runs f in the namespace of the process. Entering a namespace needs CAP_SYS_ADMIN
+func Do(ns string, f func() error) error {
+	if ns == "" {
+		return f()
+	}
+	path := Path(ns)
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("Could not open network namespace %v: %w", path, err)
+	}
+	defer unix.Close(fd)
+	errc := make(chan error, 1)
+	go func() {
+		//The thread exits with the goroutine, since it is not unlocked
+		runtime.LockOSThread()
+		if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("Could not enter network namespace %v: %v", path, err)
+			return
+		}
+		errc <- f()
+	}()
+	return <-errc
+}
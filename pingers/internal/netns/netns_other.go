@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package netns
+
+import (
+	"errors"
+)
+
+//Do runs f when ns is empty. Network namespaces are only supported on linux
+func Do(ns string, f func() error) error {
+	if ns == "" {
+		return f()
+	}
+	return errors.New("Network namespaces are only supported on linux")
+}
@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+//Package sockopt sets socket options of the connections opened by the pingers
+package sockopt
+
+import (
+	"syscall"
+)
+
+//Control returns a function to be used as net.Dialer.Control that sets the TTL and TOS of the socket.
+//Values lower than 1 are not set
+func Control(ttl, tos int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		level, ttlopt, tosopt := syscall.IPPROTO_IP, syscall.IP_TTL, syscall.IP_TOS
+		if network == "tcp6" || network == "udp6" {
+			level, ttlopt, tosopt = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, syscall.IPV6_TCLASS
+		}
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			if ttl > 0 {
+				err = syscall.SetsockoptInt(int(fd), level, ttlopt, ttl)
+			}
+			if err == nil && tos > 0 {
+				err = syscall.SetsockoptInt(int(fd), level, tosopt, tos)
+			}
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,10 @@
+package sockopt
+
+import (
+	"syscall"
+)
+
+//Control does not set TTL and TOS on windows
+func Control(ttl, tos int) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
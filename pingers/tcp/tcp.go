@@ -0,0 +1,103 @@
+//Package tcp implements a goping.Pinger that measures the time to complete a TCP handshake.
+//It can reach hosts that filter ICMP but expose TCP ports
+package tcp
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/sockopt"
+)
+
+//DefaultPort is the port used by New when it receives a port lower than 1
+const DefaultPort = 80
+
+//New returns a new Pinger that connects to port when Request.Host does not have the form host:port
+func New(port int) goping.Pinger {
+	if port < 1 {
+		port = DefaultPort
+	}
+	return &pinger{port: port}
+}
+
+//Pinger is the type the implements goping.Pinger interface
+type pinger struct {
+	port int
+}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+	//Initialize the channels used in the select stage
+	input, output, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{})
+	go p.ping(input, output, doneOutput)
+	ping, pong, done = input, output, doneOutput
+	return
+}
+
+//ping connects to the hosts of the requests in their own goroutines. Closes done after every connection is finished
+func (p pinger) ping(in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var wg sync.WaitGroup
+	var address = make(map[string]*net.TCPAddr)
+	var addressError = make(map[string]error)
+
+	for r := range in {
+		//Resolve HostName
+		if _, ok := address[r.Req.Host]; !ok {
+			address[r.Req.Host], addressError[r.Req.Host] = p.resolve(r.Req.Host)
+		}
+		if addressError[r.Req.Host] != nil {
+			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+			continue
+		}
+		wg.Add(1)
+		go func(r goping.SeqRequest, addr *net.TCPAddr) {
+			defer wg.Done()
+			if resp, ok := connect(r, addr); ok {
+				out <- resp
+			}
+		}(r, address[r.Req.Host])
+	}
+	wg.Wait()
+	close(done)
+}
+
+//resolve finds the address of host, which may have the form host:port
+func (p pinger) resolve(host string) (*net.TCPAddr, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(p.port))
+	}
+	return net.ResolveTCPAddr("tcp", host)
+}
+
+//connect does a TCP handshake with addr and builds the response.
+//Returns false when the handshake timed out, so goping reports the timeout
+func connect(r goping.SeqRequest, addr *net.TCPAddr) (goping.RawResponse, bool) {
+	resp := goping.RawResponse{Seq: r.Seq, Peer: addr.IP, RTT: math.NaN()}
+	d := net.Dialer{Timeout: r.Req.Config.Timeout, Control: sockopt.Control(r.Req.Config.TTL, r.Req.Config.TOS)}
+	start := time.Now()
+	conn, err := d.Dial("tcp", addr.String())
+	rtt := float64(time.Since(start).Nanoseconds()) / 1e6
+	var nerr net.Error
+	switch {
+	case err == nil:
+		//Handshake completed. The port is open
+		conn.Close()
+		resp.RTT, resp.Kind = rtt, goping.ReplyEcho
+	case errors.Is(err, syscall.ECONNREFUSED):
+		//The host answered with a reset. The host is reachable but the port is closed
+		resp.RTT, resp.Kind = rtt, goping.ReplyRefused
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return resp, false
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		resp.Err = goping.ErrDstUnreachable
+	default:
+		resp.Err = err
+	}
+	return resp, true
+}
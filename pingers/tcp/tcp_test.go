@@ -0,0 +1,86 @@
+package tcp
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+func TestPinger(t *testing.T) {
+	//A listening port answers the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	open := l.Addr().String()
+
+	//A port that was listening and is now closed answers with a reset
+	cl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(cl.Addr().String())
+	cl.Close()
+
+	cfg := goping.Config{Count: 3, Interval: 10 * time.Millisecond, Timeout: time.Second, TTL: 64}
+	g := goping.New(cfg, New(0), nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	reqs := []goping.Request{g.NewRequest(open, nil), g.NewRequest("127.0.0.1:"+port, nil)}
+	expected := map[uint64]goping.ReplyKind{reqs[0].ID: goping.ReplyEcho, reqs[1].ID: goping.ReplyRefused}
+	go func() {
+		for _, r := range reqs {
+			ping <- r
+		}
+		close(ping)
+	}()
+
+	var received int
+	for r := range pong {
+		received++
+		if r.Err != nil {
+			t.Errorf("Error not expected for %v: %v", r.Request.Host, r.Err)
+			continue
+		}
+		if r.Kind != expected[r.Request.ID] {
+			t.Errorf("No match Kind for %v. Expected: [%v], Got: [%v]", r.Request.Host, expected[r.Request.ID], r.Kind)
+		}
+		if !r.Peer.Equal(net.IPv4(127, 0, 0, 1)) {
+			t.Errorf("No match Peer. Expected: [%v], Got: [%v]", "127.0.0.1", r.Peer)
+		}
+		if math.IsNaN(r.RTT) || r.RTT < 0 {
+			t.Errorf("Invalid RTT: %v", r.RTT)
+		}
+	}
+	if received != 2*cfg.Count {
+		t.Errorf("No match responses. Expected: [%v], Got: [%v]", 2*cfg.Count, received)
+	}
+}
+
+func TestResolveDefaultPort(t *testing.T) {
+	addr, err := pinger{port: 8080}.resolve("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if addr.Port != 8080 {
+		t.Errorf("No match Port. Expected: [%v], Got: [%v]", 8080, addr.Port)
+	}
+	if addr, _ = (pinger{port: 8080}).resolve("[::1]:22"); addr.Port != 22 {
+		t.Errorf("No match Port. Expected: [%v], Got: [%v]", 22, addr.Port)
+	}
+}
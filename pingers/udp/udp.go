@@ -0,0 +1,117 @@
+//Package udp implements a goping.Pinger that sends UDP datagrams, in the style of the traceroute UDP mode.
+//Either a reply from the service or an ICMP port unreachable proves that the host is alive
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gracig/goping"
+	"github.com/gracig/goping/pingers/internal/sockopt"
+)
+
+//DefaultPort is the port used by New when it receives a port lower than 1. It is the first port used by traceroute, usually closed
+const DefaultPort = 33434
+
+//New returns a new Pinger that sends datagrams to port when Request.Host does not have the form host:port
+func New(port int) goping.Pinger {
+	if port < 1 {
+		port = DefaultPort
+	}
+	return &pinger{port: port}
+}
+
+//Pinger is the type the implements goping.Pinger interface
+type pinger struct {
+	port int
+}
+
+//Start is the implementation of the method goping.Pinger.Start
+func (p pinger) Start(pid int) (ping chan<- goping.SeqRequest, pong <-chan goping.RawResponse, done <-chan struct{}, err error) {
+	//Initialize the channels used in the select stage
+	input, output, doneOutput := make(chan goping.SeqRequest), make(chan goping.RawResponse), make(chan struct{})
+	go p.ping(pid, input, output, doneOutput)
+	ping, pong, done = input, output, doneOutput
+	return
+}
+
+//ping probes the hosts of the requests in their own goroutines. Closes done after every probe is finished
+func (p pinger) ping(pid int, in <-chan goping.SeqRequest, out chan<- goping.RawResponse, done chan<- struct{}) {
+	var wg sync.WaitGroup
+	var address = make(map[string]*net.UDPAddr)
+	var addressError = make(map[string]error)
+
+	for r := range in {
+		//Resolve HostName
+		if _, ok := address[r.Req.Host]; !ok {
+			address[r.Req.Host], addressError[r.Req.Host] = p.resolve(r.Req.Host)
+		}
+		if addressError[r.Req.Host] != nil {
+			out <- goping.RawResponse{Seq: r.Seq, Err: errors.New("Could not resolve address"), RTT: math.NaN()}
+			continue
+		}
+		wg.Add(1)
+		go func(r goping.SeqRequest, addr *net.UDPAddr) {
+			defer wg.Done()
+			if resp, ok := probe(pid, r, addr); ok {
+				out <- resp
+			}
+		}(r, address[r.Req.Host])
+	}
+	wg.Wait()
+	close(done)
+}
+
+//resolve finds the address of host, which may have the form host:port
+func (p pinger) resolve(host string) (*net.UDPAddr, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, strconv.Itoa(p.port))
+	}
+	return net.ResolveUDPAddr("udp", host)
+}
+
+//probe sends a datagram to addr from a connected socket and waits for the first answer.
+//The kernel reports an ICMP port unreachable received by a connected socket as a refused connection.
+//Returns false when nothing was received before the timeout, so goping reports the timeout
+func probe(pid int, r goping.SeqRequest, addr *net.UDPAddr) (goping.RawResponse, bool) {
+	resp := goping.RawResponse{Seq: r.Seq, Peer: addr.IP, RTT: math.NaN()}
+	d := net.Dialer{Control: sockopt.Control(r.Req.Config.TTL, r.Req.Config.TOS)}
+	conn, err := d.Dial("udp", addr.String())
+	if err != nil {
+		resp.Err = err
+		return resp, true
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.Req.Config.Timeout))
+
+	//The payload identifies the probe to services that log or echo it
+	payload := []byte(fmt.Sprintf("goping %d %d", pid, r.Seq))
+	buf := make([]byte, 1500)
+	start := time.Now()
+	if _, err = conn.Write(payload); err == nil {
+		_, err = conn.Read(buf)
+	}
+	rtt := float64(time.Since(start).Nanoseconds()) / 1e6
+	var nerr net.Error
+	switch {
+	case err == nil:
+		//The service answered
+		resp.RTT, resp.Kind = rtt, goping.ReplyEcho
+	case errors.Is(err, syscall.ECONNREFUSED):
+		//The host answered with an ICMP port unreachable
+		resp.RTT, resp.Kind = rtt, goping.ReplyRefused
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return resp, false
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		resp.Err = goping.ErrDstUnreachable
+	default:
+		resp.Err = err
+	}
+	return resp, true
+}
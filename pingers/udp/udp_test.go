@@ -0,0 +1,69 @@
+package udp
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gracig/goping"
+)
+
+func TestPinger(t *testing.T) {
+	//A service that echoes the datagrams
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	//A port that was listening and is now closed answers with an ICMP port unreachable
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	closed.Close()
+
+	cfg := goping.Config{Count: 3, Interval: 10 * time.Millisecond, Timeout: time.Second, TTL: 64}
+	g := goping.New(cfg, New(0), nil, nil)
+	ping, pong, err := g.Start(time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	reqs := []goping.Request{g.NewRequest(echo.LocalAddr().String(), nil), g.NewRequest(closed.LocalAddr().String(), nil)}
+	expected := map[uint64]goping.ReplyKind{reqs[0].ID: goping.ReplyEcho, reqs[1].ID: goping.ReplyRefused}
+	go func() {
+		for _, r := range reqs {
+			ping <- r
+		}
+		close(ping)
+	}()
+
+	var received int
+	for r := range pong {
+		received++
+		if r.Err != nil {
+			t.Errorf("Error not expected for %v: %v", r.Request.Host, r.Err)
+			continue
+		}
+		if r.Kind != expected[r.Request.ID] {
+			t.Errorf("No match Kind for %v. Expected: [%v], Got: [%v]", r.Request.Host, expected[r.Request.ID], r.Kind)
+		}
+		if math.IsNaN(r.RTT) || r.RTT < 0 {
+			t.Errorf("Invalid RTT: %v", r.RTT)
+		}
+	}
+	if received != 2*cfg.Count {
+		t.Errorf("No match responses. Expected: [%v], Got: [%v]", 2*cfg.Count, received)
+	}
+}
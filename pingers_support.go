@@ -2,6 +2,7 @@ package goping
 
 import (
 	"errors"
+	"net"
 	"sync"
 )
 
@@ -10,18 +11,66 @@ import (
 //Pinger is responsible for  the low implementation to send and receive pings over the network
 type Pinger interface {
 	//Start initiate the channels where the pinger will receive requests and should send the responses.
+	//pid is the first ICMP identifier owned by the session. The identifier of each ping is in SeqRequest.ID
 	Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, done <-chan struct{}, err error)
 }
 
+//Resolver is implemented by the pingers that send to IP addresses and set the Dst of their responses.
+//Sessions resolve each host once with it and correlate the replies by destination and sequence, so each destination has its own sequences
+type Resolver interface {
+	//Resolve returns the address the pings to host are sent to
+	Resolve(host string) (net.IP, error)
+}
+
+//IdentSet is a set of ICMP identifiers safe for concurrent use.
+//Pingers with a socket per session add the identifier of each ping sent, to accept only the replies to their session
+type IdentSet struct {
+	mu  sync.RWMutex
+	ids map[int]bool
+}
+
+//Add adds the identifier id to the set
+func (s *IdentSet) Add(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[int]bool)
+	}
+	s.ids[id&0xffff] = true
+}
+
+//Has tells whether the identifier id is in the set
+func (s *IdentSet) Has(id int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ids[id&0xffff]
+}
+
 /*** Errors ***/
 var (
-	ErrTimeout             = errors.New("Timeout")
-	ErrDstUnreachable      = errors.New("Destination Unreachable")
-	ErrParamProblem        = errors.New("Parameter problem")
-	ErrTimeExceeded        = errors.New("Time Exceeded")
-	ErrRedirect            = errors.New("Redirect Message")
-	ErrUnknown             = errors.New("Unknown Packet")
-	ErrPingerNotRegistered = errors.New("Ping not registered")
+	ErrTimeout              = errors.New("Timeout")
+	ErrCancelled            = errors.New("Cancelled")
+	ErrDstUnreachable       = errors.New("Destination Unreachable")
+	ErrParamProblem         = errors.New("Parameter problem")
+	ErrTimeExceeded         = errors.New("Time Exceeded")
+	ErrPacketTooBig         = errors.New("Packet Too Big")
+	ErrRedirect             = errors.New("Redirect Message")
+	ErrUnknown              = errors.New("Unknown Packet")
+	ErrNetUnreachable       = errors.New("Net Unreachable")
+	ErrHostUnreachable      = errors.New("Host Unreachable")
+	ErrProtocolUnreachable  = errors.New("Protocol Unreachable")
+	ErrPortUnreachable      = errors.New("Port Unreachable")
+	ErrAdminProhibited      = errors.New("Administratively Prohibited")
+	ErrTTLExceeded          = errors.New("TTL Exceeded in Transit")
+	ErrReassemblyExceeded   = errors.New("Fragment Reassembly Time Exceeded")
+	ErrCorruptPayload       = errors.New("Corrupted Payload")
+	ErrMessageTooLong       = errors.New("Message too long")
+	ErrWindowExhausted      = errors.New("Every sequence is waiting for a reply")
+	ErrIdentifiersExhausted = errors.New("Every ICMP identifier is owned by a session")
+	ErrPingerNotRegistered  = errors.New("Ping not registered")
+	ErrSourceNotLocal       = errors.New("Source address is not local")
+	ErrNoSuchInterface      = errors.New("No such interface")
+	ErrMarkNotSupported     = errors.New("Packet mark not supported")
 
 	ErrCouldNotStartPinger = errors.New("Could not start pinger")
 )
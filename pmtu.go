@@ -0,0 +1,185 @@
+package goping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+/*** Path MTU Discovery ***/
+
+//PMTUConfig configures a DiscoverPMTU. Zero values are replaced by the defaults
+type PMTUConfig struct {
+	Min            int           //The smallest packet size probed. Default 68, the minimum MTU of IPv4
+	Max            int           //The largest packet size probed. Default 1500
+	Overhead       int           //The size of the headers before the data of a ping, so Config.PacketSize is the packet size minus Overhead. Default 28, the IPv4 and ICMP headers
+	Probes         int           //The number of probes of a size lost before the size is considered too big. Default 2
+	SmoothInterval time.Duration //The minimum interval between all probes. Default 1ms
+}
+
+func (c PMTUConfig) withDefaults() PMTUConfig {
+	if c.Min < 1 {
+		c.Min = 68
+	}
+	if c.Max < 1 {
+		c.Max = 1500
+	}
+	if c.Overhead < 1 {
+		c.Overhead = 28
+	}
+	if c.Probes < 1 {
+		c.Probes = 2
+	}
+	if c.SmoothInterval <= 0 {
+		c.SmoothInterval = time.Millisecond
+	}
+	return c
+}
+
+//PMTU is the path MTU found to a host
+type PMTU struct {
+	Host      string
+	MTU       int    //The largest packet size that reached the host, up to PMTUConfig.Max. 0 when no size reached it
+	Router    net.IP //The last router that answered Fragmentation Needed. nil when none did
+	BlackHole bool   //True when the larger packets were lost without a Fragmentation Needed, as when routers drop them silently or ICMP is filtered
+	Probes    int    //The number of probes sent
+	Err       error  //The error that stopped the discovery, or the answer to the smallest size when no size reached the host
+}
+
+//pmtuState is the binary search of the path MTU to a host
+type pmtuState struct {
+	PMTU
+	id     uint64 //The request probing the current size
+	size   int    //The current size
+	lo, hi int    //The largest size that reached the host and the smallest size too big. The path MTU is between them
+	hint   int    //The next hop MTU answered by a router, probed next
+	lost   bool   //True when hi was lost instead of answered with Fragmentation Needed
+	failed bool   //True when the host answered an error that stops the discovery
+	done   bool
+}
+
+//next returns the next size to probe, or 0 when the path MTU is found
+func (s *pmtuState) next(cfg PMTUConfig) int {
+	low := s.lo
+	if low < cfg.Min-1 {
+		low = cfg.Min - 1
+	}
+	if s.hi-low <= 1 {
+		return 0
+	}
+	//The largest size is probed first, since most paths carry it
+	if s.Probes == 0 {
+		return cfg.Max
+	}
+	if hint := s.hint; hint > low && hint < s.hi {
+		s.hint = 0
+		return hint
+	}
+	return (low + s.hi) / 2
+}
+
+//DiscoverPMTU finds the path MTU to each host with a binary search of the packet size of pings with the Don't Fragment bit set.
+//A size is too big when a router answers Fragmentation Needed, and the next hop MTU of the answer is probed next, or when its Probes are lost.
+//The hosts are probed at the same time, with the Interval and Timeout of the Config of g. The pinger must honor Config.DontFragment, as the icmpv4 pingers do.
+//The results are returned in the order of hosts. When ctx is cancelled the results found so far are returned with the error of the context
+func DiscoverPMTU(ctx context.Context, g GoPinger, hosts []string, cfg PMTUConfig) ([]PMTU, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Min > cfg.Max || cfg.Min <= cfg.Overhead {
+		return nil, fmt.Errorf("Invalid packet sizes from %v to %v with an overhead of %v", cfg.Min, cfg.Max, cfg.Overhead)
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ping, pong, err := g.StartContext(sctx, cfg.SmoothInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*pmtuState, len(hosts))
+	byID := make(map[uint64]*pmtuState)
+	//Number of hosts whose path MTU is not found yet
+	running := len(hosts)
+	//No more requests are sent after the ping channel is closed
+	closed := false
+	stop := func() {
+		if !closed {
+			close(ping)
+			closed = true
+		}
+	}
+	defer stop()
+	//Probes the next size of s, or finishes s when its path MTU is found
+	advance := func(s *pmtuState) {
+		if s.size = s.next(cfg); s.size == 0 || closed {
+			s.done, s.MTU = true, s.lo
+			s.BlackHole = s.lo > 0 && s.lost && !s.failed
+			if s.lo > 0 && !s.failed {
+				s.Err = nil
+			}
+			if running--; running == 0 {
+				stop()
+			}
+			return
+		}
+		req := g.NewRequest(s.Host, nil)
+		req.Config.Count, req.Config.DontFragment, req.Config.PacketSize = cfg.Probes, true, s.size-cfg.Overhead
+		s.id, byID[req.ID] = req.ID, s
+		ping <- req
+	}
+	for i, host := range hosts {
+		states[i] = &pmtuState{PMTU: PMTU{Host: host}, hi: cfg.Max + 1}
+		advance(states[i])
+	}
+	if running == 0 {
+		stop()
+	}
+
+	for r := range pong {
+		s := byID[r.Request.ID]
+		//Responses of sizes already decided are discarded
+		if s == nil || s.done || s.id != r.Request.ID || r.Err == ErrCancelled || r.Duplicate {
+			continue
+		}
+		if !r.Late {
+			s.Probes++
+		}
+		var ie *ICMPError
+		switch {
+		case r.Err == nil || r.Err == ErrCorruptPayload:
+			//The size reached the host, even when the reply was late
+			s.lo = s.size
+		case errors.Is(r.Err, ErrPacketTooBig) || errors.Is(r.Err, ErrMessageTooLong):
+			s.hi, s.lost, s.Err = s.size, false, r.Err
+			if errors.As(r.Err, &ie) {
+				s.Router = ie.Router
+				//The router does not forward the sizes larger than its next hop MTU
+				if ie.MTU > 0 && ie.MTU < s.size {
+					s.hi, s.hint = ie.MTU+1, ie.MTU
+				}
+			}
+		case r.Err == ErrTimeout:
+			//The size is too big only when every probe was lost
+			if int(r.Request.Sent) < r.Request.Config.Count {
+				continue
+			}
+			s.hi, s.lost, s.Err = s.size, true, r.Err
+		default:
+			//The host can not be reached, like when a router answers Destination Unreachable
+			s.Err, s.failed, s.hi = r.Err, true, 0
+		}
+		//The remaining probes of the size are not needed
+		g.Cancel(s.id)
+		delete(byID, s.id)
+		advance(s)
+	}
+
+	results := make([]PMTU, len(states))
+	for i, s := range states {
+		results[i] = s.PMTU
+		if !s.done {
+			results[i].MTU = s.lo
+		}
+	}
+	return results, ctx.Err()
+}
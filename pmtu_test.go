@@ -0,0 +1,116 @@
+package goping
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+//mtuPath simulates the path to a host. Packets larger than mtu are answered with Fragmentation Needed by router, or lost when silent
+type mtuPath struct {
+	mtu    int
+	router net.IP
+	silent bool
+	zero   bool  //The router does not send the next hop MTU, as RFC 792 routers
+	local  int   //The MTU of the local interface. Larger packets are not sent
+	err    error //The answer of the host to every ping
+}
+
+//mtuPinger simulates the paths to the hosts. Packet sizes are PacketSize plus 28
+type mtuPinger struct {
+	paths map[string]mtuPath
+	df    bool //True when every ping had DontFragment
+}
+
+func (m *mtuPinger) Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, donepong <-chan struct{}, err error) {
+	in, out, done := make(chan SeqRequest), make(chan RawResponse), make(chan struct{})
+	m.df = true
+	go func() {
+		for r := range in {
+			p, size := m.paths[r.Req.Host], r.Req.Config.PacketSize+28
+			m.df = m.df && r.Req.Config.DontFragment
+			switch {
+			case p.local > 0 && size > p.local:
+				out <- RawResponse{Seq: r.Seq, Err: ErrMessageTooLong}
+			case p.err != nil:
+				out <- RawResponse{Seq: r.Seq, Peer: p.router, Err: p.err}
+			case size <= p.mtu:
+				out <- RawResponse{Seq: r.Seq, Peer: net.IPv4(10, 0, 0, 9), RTT: 1}
+			case p.silent:
+			default:
+				b := []byte{3, 4, 0, 0, 0, 0, byte(p.mtu >> 8), byte(p.mtu)}
+				if p.zero {
+					b[6], b[7] = 0, 0
+				}
+				e, _ := ParseICMPError(ProtocolICMP, p.router, b)
+				out <- RawResponse{Seq: r.Seq, Peer: p.router, RTT: 1, Err: e}
+			}
+		}
+		close(done)
+	}()
+	return in, out, done, nil
+}
+
+func TestDiscoverPMTU(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	router := net.IPv4(10, 0, 0, 1)
+	p := &mtuPinger{paths: map[string]mtuPath{
+		"clear":       {mtu: 1500},
+		"tunnel":      {mtu: 1400, router: router},
+		"rfc792":      {mtu: 1372, router: router, zero: true},
+		"blackhole":   {mtu: 1420, silent: true},
+		"local":       {mtu: 1500, local: 1280},
+		"unreachable": {router: router, err: ErrHostUnreachable},
+		"down":        {silent: true},
+	}}
+	hosts := []string{"clear", "tunnel", "rfc792", "blackhole", "local", "unreachable", "down"}
+	results, err := DiscoverPMTU(context.Background(), New(cfg, p, nil, nil), hosts, PMTUConfig{})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if !p.df {
+		t.Errorf("Every probe should have the Don't Fragment bit")
+	}
+	tt := []struct {
+		mtu       int
+		router    net.IP
+		blackHole bool
+		err       error
+	}{
+		{mtu: 1500},
+		{mtu: 1400, router: router},
+		{mtu: 1372, router: router},
+		{mtu: 1420, blackHole: true},
+		{mtu: 1280},
+		{err: ErrHostUnreachable},
+		{err: ErrTimeout},
+	}
+	for i, tc := range tt {
+		r := results[i]
+		if r.Host != hosts[i] || r.MTU != tc.mtu || !r.Router.Equal(tc.router) || r.BlackHole != tc.blackHole || !errors.Is(r.Err, tc.err) {
+			t.Errorf("No match for %v. Expected: [%v %v %v %v], Got: [%v %v %v %v]", hosts[i], tc.mtu, tc.router, tc.blackHole, tc.err, r.MTU, r.Router, r.BlackHole, r.Err)
+		}
+	}
+	//The path that carries the largest size needs one probe, and the next hop MTU answered by the router is probed next
+	if results[0].Probes != 1 || results[1].Probes != 2 {
+		t.Errorf("No match probes. Expected: [1 2], Got: [%v %v]", results[0].Probes, results[1].Probes)
+	}
+}
+
+func TestDiscoverPMTUConfig(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	if _, err := DiscoverPMTU(context.Background(), New(cfg, &mtuPinger{}, nil, nil), []string{"a"}, PMTUConfig{Min: 1500, Max: 1000}); err == nil {
+		t.Errorf("Expected an error when Min is greater than Max")
+	}
+	//Sizes are searched between Min and Max only
+	p := &mtuPinger{paths: map[string]mtuPath{"a": {mtu: 1000, router: net.IPv4(10, 0, 0, 1), zero: true}}}
+	results, err := DiscoverPMTU(context.Background(), New(cfg, p, nil, nil), []string{"a"}, PMTUConfig{Min: 1200, Max: 9000})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if results[0].MTU != 0 || !errors.Is(results[0].Err, ErrPacketTooBig) {
+		t.Errorf("Expected no size between Min and Max. Got: [%v %v]", results[0].MTU, results[0].Err)
+	}
+}
@@ -0,0 +1,292 @@
+package goping
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+)
+
+/*** Statistics ***/
+
+//Stats holds the statistics of the responses of a Request. RTT values are in milliseconds and are NaN while no RTT was measured
+type Stats struct {
+	ID       uint64
+	Host     string
+	UserData map[string]string
+
+	Sent     int            //Number of pings that got a response or an error
+	Received int            //Number of pings that got a response without error
+	Loss     float64        //Percentage of sent pings not received
+	Min      float64        //Minimum RTT
+	Avg      float64        //Average RTT
+	Max      float64        //Maximum RTT
+	StdDev   float64        //Standard deviation of the RTT. The mdev of the ping command
+	Jitter   float64        //Interarrival jitter estimation as defined in RFC 3550
+	P50      float64        //Median RTT
+	P90      float64        //90th percentile RTT
+	P99      float64        //99th percentile RTT
+	P999     float64        //99.9th percentile RTT
+	Errors   map[string]int //Number of pings by error found
+	Done     bool           //True when the request received its last response
+
+	Duplicates int //Number of duplicate replies. They are not counted in Received
+	Late       int //Number of replies that arrived after their ping timed out. They are not counted in Received
+	Reordered  int //Number of duplicate or late replies that arrived after the reply of a later ping
+
+	//Histogram of the received RTTs. Can be merged with the histograms of other requests
+	Histogram *Histogram
+}
+
+//statsFields has the fields of Stats without its methods
+type statsFields Stats
+
+//statsJSON is the JSON representation of Stats. Its RTT fields take precedence over the ones of statsFields, and are null when NaN
+type statsJSON struct {
+	statsFields
+	Min, Avg, Max, StdDev, Jitter *float64
+	P50, P90, P99, P999           *float64
+}
+
+//rtts returns the RTT fields of s and their JSON representation in sj
+func (s *Stats) rtts(sj *statsJSON) ([]*float64, []**float64) {
+	return []*float64{&s.Min, &s.Avg, &s.Max, &s.StdDev, &s.Jitter, &s.P50, &s.P90, &s.P99, &s.P999},
+		[]**float64{&sj.Min, &sj.Avg, &sj.Max, &sj.StdDev, &sj.Jitter, &sj.P50, &sj.P90, &sj.P99, &sj.P999}
+}
+
+//MarshalJSON implements json.Marshaler. JSON has no NaN, so the RTTs not measured are null
+func (s Stats) MarshalJSON() ([]byte, error) {
+	sj := statsJSON{statsFields: statsFields(s)}
+	values, fields := s.rtts(&sj)
+	for i, v := range values {
+		if !math.IsNaN(*v) {
+			f := *v
+			*fields[i] = &f
+		}
+	}
+	return json.Marshal(sj)
+}
+
+//UnmarshalJSON implements json.Unmarshaler. Null or missing RTTs are NaN
+func (s *Stats) UnmarshalJSON(b []byte) error {
+	var sj statsJSON
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return err
+	}
+	*s = Stats(sj.statsFields)
+	values, fields := s.rtts(&sj)
+	for i, v := range values {
+		*v = math.NaN()
+		if *fields[i] != nil {
+			*v = **fields[i]
+		}
+	}
+	return nil
+}
+
+//runningStats accumulates RTT samples without keeping them
+type runningStats struct {
+	sent, received int
+	samples        int //Number of received pings with a measured RTT
+	min, max       float64
+	mean, m2       float64 //Welford's running mean and sum of squared differences
+	jitter         float64
+	last           float64 //Last RTT received. Used to compute the jitter
+	errors         map[string]int
+	hist           *Histogram
+
+	duplicates, late, reordered int
+	newest                      int //The greatest ping number of a request that was answered
+}
+
+func newRunningStats() *runningStats {
+	return &runningStats{min: math.Inf(1), max: math.Inf(-1), errors: make(map[string]int), hist: NewHistogram()}
+}
+
+//add accounts a ping outcome. err is nil when the ping was received with the given rtt
+func (s *runningStats) add(rtt float64, err error) {
+	s.sent++
+	if err != nil {
+		s.errors[err.Error()]++
+		return
+	}
+	s.received++
+	//Some answers do not carry a measurable RTT
+	if math.IsNaN(rtt) {
+		return
+	}
+	s.samples++
+	s.min = math.Min(s.min, rtt)
+	s.max = math.Max(s.max, rtt)
+	delta := rtt - s.mean
+	s.mean += delta / float64(s.samples)
+	s.m2 += delta * (rtt - s.mean)
+	if s.samples > 1 {
+		//J = J + (|D(i-1,i)| - J)/16
+		s.jitter += (math.Abs(rtt-s.last) - s.jitter) / 16
+	}
+	s.last = rtt
+	s.hist.Add(rtt)
+}
+
+//answered accounts the order of the replies. n is the number of the ping answered in its request, which is Request.Sent when it was sent
+func (s *runningStats) answered(n int) {
+	if n > s.newest {
+		s.newest = n
+	}
+}
+
+//addExtra accounts a duplicate or late response. They do not change the other statistics
+func (s *runningStats) addExtra(r Response) {
+	if r.Duplicate {
+		s.duplicates++
+	} else {
+		s.late++
+	}
+	if int(r.Request.Sent) < s.newest {
+		s.reordered++
+	}
+	s.answered(int(r.Request.Sent))
+}
+
+//fill copies the accumulated values to st
+func (s *runningStats) fill(st *Stats) {
+	st.Sent, st.Received = s.sent, s.received
+	st.Duplicates, st.Late, st.Reordered = s.duplicates, s.late, s.reordered
+	st.Min, st.Avg, st.Max, st.StdDev, st.Jitter = math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()
+	if s.sent > 0 {
+		st.Loss = float64(s.sent-s.received) * 100 / float64(s.sent)
+	}
+	if s.samples > 0 {
+		st.Min, st.Avg, st.Max = s.min, s.mean, s.max
+		st.StdDev = math.Sqrt(s.m2 / float64(s.samples))
+		st.Jitter = s.jitter
+	}
+	st.P50, st.P90 = s.hist.Quantile(0.5), s.hist.Quantile(0.9)
+	st.P99, st.P999 = s.hist.Quantile(0.99), s.hist.Quantile(0.999)
+	st.Histogram = s.hist.Clone()
+	st.Errors = make(map[string]int, len(s.errors))
+	for k, v := range s.errors {
+		st.Errors[k] = v
+	}
+}
+
+//requestStats are the running statistics of a Request
+type requestStats struct {
+	req  Request
+	done bool
+	runningStats
+}
+
+func (s *requestStats) snapshot() Stats {
+	st := Stats{ID: s.req.ID, Host: s.req.Host, UserData: s.req.UserData, Done: s.done}
+	s.fill(&st)
+	return st
+}
+
+//StatsAggregator maintains the statistics of the responses of each Request, indexed by Request.ID
+type StatsAggregator struct {
+	mu     sync.Mutex
+	stats  map[uint64]*requestStats
+	onDone func(Stats)
+}
+
+//NewStatsAggregator creates a StatsAggregator. onDone, if not nil, receives the final summary of each request once its last response is accounted
+func NewStatsAggregator(onDone func(Stats)) *StatsAggregator {
+	return &StatsAggregator{stats: make(map[uint64]*requestStats), onDone: onDone}
+}
+
+//Add accounts a response and returns the updated statistics of its request
+func (a *StatsAggregator) Add(r Response) Stats {
+	a.mu.Lock()
+	s, finished := a.add(r)
+	st := s.snapshot()
+	a.mu.Unlock()
+
+	if finished && a.onDone != nil {
+		a.onDone(st)
+	}
+	return st
+}
+
+//add accounts a response with the lock held. Returns the statistics of its request, and true when the response finished the request
+func (a *StatsAggregator) add(r Response) (*requestStats, bool) {
+	s, ok := a.stats[r.Request.ID]
+	if !ok {
+		s = &requestStats{runningStats: *newRunningStats()}
+		a.stats[r.Request.ID] = s
+	}
+	finished := false
+	switch {
+	case r.Duplicate || r.Late:
+		//Extra responses do not finish the request. They carry the request as it was when the ping was sent
+		s.addExtra(r)
+	default:
+		s.req = r.Request
+		//A cancelled response only tells that the request is finished
+		if r.Err != ErrCancelled {
+			s.add(r.RTT, r.Err)
+		}
+		if r.Err == nil {
+			s.answered(int(r.Request.Sent))
+		}
+		done := r.Err == ErrCancelled || (r.Request.Config.Count >= 0 && int(r.Request.Sent) >= r.Request.Config.Count)
+		//onDone is called once, when the request becomes done. Later extra responses only update the statistics
+		finished = done && !s.done
+		s.done = s.done || done
+	}
+	return s, finished
+}
+
+//Consume accounts every response read from in and forwards it to the returned channel, which is closed after in is closed.
+//The statistics are only computed for onDone and when they are read
+func (a *StatsAggregator) Consume(in <-chan Response) <-chan Response {
+	out := make(chan Response)
+	go func() {
+		for r := range in {
+			a.mu.Lock()
+			s, finished := a.add(r)
+			notify := finished && a.onDone != nil
+			var st Stats
+			if notify {
+				st = s.snapshot()
+			}
+			a.mu.Unlock()
+			if notify {
+				a.onDone(st)
+			}
+			out <- r
+		}
+		close(out)
+	}()
+	return out
+}
+
+//Snapshot returns the current statistics of the request with the given id
+func (a *StatsAggregator) Snapshot(id uint64) (Stats, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s, ok := a.stats[id]; ok {
+		return s.snapshot(), true
+	}
+	return Stats{}, false
+}
+
+//Snapshots returns the current statistics of every request ordered by id
+func (a *StatsAggregator) Snapshots() []Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]Stats, 0, len(a.stats))
+	for _, s := range a.stats {
+		list = append(list, s.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+//Delete forgets the statistics of the request with the given id
+func (a *StatsAggregator) Delete(id uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.stats, id)
+}
@@ -0,0 +1,196 @@
+package goping
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestStatsAggregator(t *testing.T) {
+	var summaries []Stats
+	a := NewStatsAggregator(func(s Stats) { summaries = append(summaries, s) })
+
+	req := Request{ID: 1, Host: "hostname", Config: Config{Count: 4}}
+	rtts := []float64{10, 20, 30, math.NaN()}
+	for i, rtt := range rtts {
+		req.Sent++
+		r := Response{Request: req, RawResponse: RawResponse{Seq: i + 1, RTT: rtt}}
+		if math.IsNaN(rtt) {
+			r.Err = ErrTimeout
+		}
+		a.Add(r)
+		if s, _ := a.Snapshot(1); s.Done != (i == len(rtts)-1) {
+			t.Errorf("No match Done after %v responses. Got: [%v]", i+1, s.Done)
+		}
+	}
+
+	s, ok := a.Snapshot(1)
+	if !ok {
+		t.Fatalf("Snapshot of request 1 not found")
+	}
+	expect := map[string][2]float64{
+		"Sent":     {4, float64(s.Sent)},
+		"Received": {3, float64(s.Received)},
+		"Loss":     {25, s.Loss},
+		"Min":      {10, s.Min},
+		"Avg":      {20, s.Avg},
+		"Max":      {30, s.Max},
+		"StdDev":   {math.Sqrt(200.0 / 3), s.StdDev},
+		"Jitter":   {0.625 + (10-0.625)/16, s.Jitter},
+		"Timeouts": {1, float64(s.Errors[ErrTimeout.Error()])},
+	}
+	for k, v := range expect {
+		if math.Abs(v[0]-v[1]) > 1e-9 {
+			t.Errorf("No match %v. Expected: [%v], Got: [%v]", k, v[0], v[1])
+		}
+	}
+	if len(summaries) != 1 || summaries[0].ID != 1 {
+		t.Errorf("Expected one final summary for request 1. Got: %v", summaries)
+	}
+}
+
+func TestStatsAggregatorCancelled(t *testing.T) {
+	a := NewStatsAggregator(nil)
+
+	req := Request{ID: 7, Config: Config{Count: -1}}
+	req.Sent++
+	a.Add(Response{Request: req, RawResponse: RawResponse{Seq: 1, RTT: math.NaN(), Err: ErrTimeout}})
+	a.Add(Response{Request: req, RawResponse: RawResponse{Seq: -1, RTT: math.NaN(), Err: ErrCancelled}})
+
+	s, _ := a.Snapshot(7)
+	if !s.Done {
+		t.Errorf("A cancelled request should be done")
+	}
+	if s.Sent != 1 || s.Received != 0 || s.Loss != 100 {
+		t.Errorf("Cancelled responses should not be accounted. Got: Sent=%v Received=%v Loss=%v", s.Sent, s.Received, s.Loss)
+	}
+	if !math.IsNaN(s.Avg) {
+		t.Errorf("Avg should be NaN when nothing was received. Got: %v", s.Avg)
+	}
+	if list := a.Snapshots(); len(list) != 1 {
+		t.Errorf("Expected 1 snapshot. Got: %v", len(list))
+	}
+	a.Delete(7)
+	if _, ok := a.Snapshot(7); ok {
+		t.Errorf("Snapshot should not exist after Delete")
+	}
+}
+
+func TestStatsAggregatorExtra(t *testing.T) {
+	a := NewStatsAggregator(nil)
+
+	req := Request{ID: 3, Config: Config{Count: 3}}
+	add := func(sent int, r Response) {
+		r.Request = req
+		r.Request.Sent = float64(sent)
+		a.Add(r)
+	}
+	add(1, Response{RawResponse: RawResponse{RTT: math.NaN(), Err: ErrTimeout}})
+	add(2, Response{RawResponse: RawResponse{RTT: 10}})
+	add(2, Response{RawResponse: RawResponse{RTT: 11}, Duplicate: true})
+	//The reply of the first ping arrives after the reply of the second
+	add(1, Response{RawResponse: RawResponse{RTT: 40}, Late: true})
+	add(3, Response{RawResponse: RawResponse{RTT: 10}})
+
+	s, _ := a.Snapshot(3)
+	if s.Sent != 3 || s.Received != 2 || s.Duplicates != 1 || s.Late != 1 || s.Reordered != 1 {
+		t.Errorf("No match counters. Expected: [3 2 1 1 1], Got: [%v %v %v %v %v]", s.Sent, s.Received, s.Duplicates, s.Late, s.Reordered)
+	}
+	if s.Max != 10 || !s.Done {
+		t.Errorf("Extra responses should not change the RTTs or finish the request. Got: Max=%v Done=%v", s.Max, s.Done)
+	}
+}
+
+func TestStatsAggregatorDoneOnce(t *testing.T) {
+	var done []Stats
+	a := NewStatsAggregator(func(s Stats) { done = append(done, s) })
+
+	req := Request{ID: 5, Config: Config{Count: 2}}
+	add := func(sent int, r Response) {
+		r.Request = req
+		r.Request.Sent = float64(sent)
+		a.Add(r)
+	}
+	add(1, Response{RawResponse: RawResponse{RTT: 10}})
+	add(2, Response{RawResponse: RawResponse{RTT: 12}})
+	//Extra replies after the last one do not finish the request again
+	add(2, Response{RawResponse: RawResponse{RTT: 13}, Duplicate: true})
+	add(1, Response{RawResponse: RawResponse{RTT: 50}, Late: true})
+
+	if len(done) != 1 {
+		t.Fatalf("No match onDone calls. Expected: [1], Got: [%v]", len(done))
+	}
+	if done[0].Received != 2 || done[0].Duplicates != 0 || !done[0].Done {
+		t.Errorf("No match summary. Expected: [2 0 true], Got: [%v %v %v]", done[0].Received, done[0].Duplicates, done[0].Done)
+	}
+	if s, _ := a.Snapshot(5); s.Duplicates != 1 || s.Late != 1 || !s.Done {
+		t.Errorf("No match snapshot. Expected: [1 1 true], Got: [%v %v %v]", s.Duplicates, s.Late, s.Done)
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	a := NewStatsAggregator(nil)
+	req := Request{ID: 9, Host: "localhost", Config: Config{Count: 2}}
+	req.Sent = 1
+	//No RTT was measured yet, so every RTT field is NaN
+	a.Add(Response{Request: req, RawResponse: RawResponse{RTT: math.NaN(), Err: ErrTimeout}})
+	empty, _ := a.Snapshot(9)
+	req.Sent = 2
+	a.Add(Response{Request: req, RawResponse: RawResponse{RTT: 10}})
+	full, _ := a.Snapshot(9)
+
+	for _, s := range []Stats{empty, full} {
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		var got Stats
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("Error not expected: %v", err)
+		}
+		values, _ := s.rtts(&statsJSON{})
+		gotValues, _ := got.rtts(&statsJSON{})
+		for i, v := range values {
+			if *v != *gotValues[i] && !(math.IsNaN(*v) && math.IsNaN(*gotValues[i])) {
+				t.Errorf("No match RTT field %v. Expected: [%v] Got: [%v]", i, *v, *gotValues[i])
+			}
+		}
+		if got.Sent != s.Sent || got.Received != s.Received || got.Host != s.Host || got.Errors[ErrTimeout.Error()] != 1 ||
+			got.Histogram.Count() != s.Histogram.Count() {
+			t.Errorf("No match Stats. Expected: [%+v] Got: [%+v]", s, got)
+		}
+	}
+	if b, _ := json.Marshal(empty); !strings.Contains(string(b), `"Min":null`) {
+		t.Errorf("NaN should be null. Got: %s", b)
+	}
+}
+
+func TestStatsAggregatorConsume(t *testing.T) {
+	var done []Stats
+	a := NewStatsAggregator(func(s Stats) { done = append(done, s) })
+	in := make(chan Response)
+	out := a.Consume(in)
+	go func() {
+		req := Request{ID: 11, Config: Config{Count: 3}}
+		for sent := 1; sent <= 3; sent++ {
+			req.Sent = float64(sent)
+			in <- Response{Request: req, RawResponse: RawResponse{RTT: float64(10 * sent)}}
+		}
+		in <- Response{Request: req, RawResponse: RawResponse{RTT: 40}, Duplicate: true}
+		close(in)
+	}()
+	forwarded := 0
+	for range out {
+		forwarded++
+	}
+	if forwarded != 4 || len(done) != 1 {
+		t.Fatalf("No match forwarded and onDone calls. Expected: [4 1], Got: [%v %v]", forwarded, len(done))
+	}
+	if done[0].Received != 3 || done[0].Avg != 20 || done[0].Histogram.Count() != 3 {
+		t.Errorf("No match summary. Expected: [3 20 3], Got: [%v %v %v]", done[0].Received, done[0].Avg, done[0].Histogram.Count())
+	}
+	if s, _ := a.Snapshot(11); s.Received != 3 || s.Duplicates != 1 || !s.Done {
+		t.Errorf("No match snapshot. Expected: [3 1 true], Got: [%v %v %v]", s.Received, s.Duplicates, s.Done)
+	}
+}
@@ -0,0 +1,182 @@
+package goping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+/*** Traceroute ***/
+
+//TraceConfig configures a Trace. Zero values are replaced by the defaults
+type TraceConfig struct {
+	FirstTTL       int           //The TTL of the first hop probed. Default 1
+	MaxTTL         int           //The TTL of the last hop probed. Default 30
+	Probes         int           //The number of probes sent to each hop. Default 3
+	FlowID         int           //When greater than 0 every probe follows the same path through load balancers, as in Paris traceroute. See Config.FlowID
+	SmoothInterval time.Duration //The minimum interval between all probes. Default 1ms
+}
+
+func (c TraceConfig) withDefaults() TraceConfig {
+	if c.FirstTTL < 1 {
+		c.FirstTTL = 1
+	}
+	if c.MaxTTL < 1 {
+		c.MaxTTL = 30
+	}
+	if c.Probes < 1 {
+		c.Probes = 3
+	}
+	if c.SmoothInterval <= 0 {
+		c.SmoothInterval = time.Millisecond
+	}
+	return c
+}
+
+//Hop is what answered the probes sent with a TTL
+type Hop struct {
+	TTL     int
+	Peers   []net.IP //The addresses that answered, in the order they first answered. More than one means the probes took different paths
+	Stats   Stats    //Statistics of the probes. Received counts the probes answered by the hop, either with a reply or an ICMP error
+	Last    float64  //RTT of the last probe answered with a measured RTT. NaN when there is none
+	Reached bool     //True when the destination answered
+	Err     error    //The error answered by the hop that ends the path, like ErrDstUnreachable. nil for routers that forwarded the probes
+}
+
+//ends reports whether probes with greater TTLs are useless
+func (h Hop) ends() bool {
+	return h.Reached || h.Err != nil
+}
+
+//Path is the result of a Trace
+type Path struct {
+	Host    string
+	Hops    []Hop //One hop for each TTL probed, up to the hop that ends the path
+	Reached bool  //True when the destination answered
+}
+
+//hopState accumulates the responses of the probes of a hop
+type hopState struct {
+	id uint64
+	Hop
+	runningStats
+}
+
+//answered reports whether the probe was answered by the hop. Only the ICMP errors sent by the hop have a peer.
+//Timeouts and local errors are losses
+func answered(r Response) bool {
+	return r.Err == nil || (r.Peer != nil && !errors.Is(r.Err, ErrTimeout))
+}
+
+//endsPath reports whether the answer comes from the destination or tells the destination can not be reached
+func endsPath(r Response) bool {
+	return answered(r) && !errors.Is(r.Err, ErrTimeExceeded) && !errors.Is(r.Err, ErrRedirect)
+}
+
+//add accounts the response of a probe
+func (h *hopState) add(r Response) {
+	if r.Duplicate || r.Late {
+		h.addExtra(r)
+		return
+	}
+	if !answered(r) {
+		h.runningStats.add(r.RTT, r.Err)
+		return
+	}
+	h.runningStats.add(r.RTT, nil)
+	if !containsIP(h.Peers, r.Peer) {
+		h.Peers = append(h.Peers, r.Peer)
+	}
+	switch {
+	case r.Err == nil:
+		h.Reached = true
+	case endsPath(r):
+		h.Err = r.Err
+	}
+}
+
+//snapshot returns the Hop with its current statistics
+func (h *hopState) snapshot(host string, done bool) Hop {
+	hop := h.Hop
+	hop.Peers = append([]net.IP(nil), h.Peers...)
+	hop.Stats = Stats{ID: h.id, Host: host, Done: done}
+	h.fill(&hop.Stats)
+	hop.Last = math.NaN()
+	if h.samples > 0 {
+		hop.Last = h.last
+	}
+	return hop
+}
+
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, v := range list {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//Trace discovers the path to host sending probes with increasing TTL through g, as traceroute does.
+//The probes of every TTL are sent at once, and the probes beyond the hop that ends the path are cancelled.
+//Interval and Timeout of the probes are taken from the Config of g. The pinger must report the ICMP errors with the address of the router, as the ICMP pingers do.
+//When ctx is cancelled the path found so far is returned with the error of the context
+func Trace(ctx context.Context, g GoPinger, host string, cfg TraceConfig) (Path, error) {
+	cfg = cfg.withDefaults()
+	if cfg.FirstTTL > cfg.MaxTTL {
+		return Path{}, fmt.Errorf("FirstTTL %v is greater than MaxTTL %v", cfg.FirstTTL, cfg.MaxTTL)
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ping, pong, err := g.StartContext(sctx, cfg.SmoothInterval)
+	if err != nil {
+		return Path{}, err
+	}
+
+	//One request for each TTL, sending Probes pings
+	reqs := make([]Request, 0, cfg.MaxTTL-cfg.FirstTTL+1)
+	hops := make([]*hopState, 0, cap(reqs))
+	byID := make(map[uint64]*hopState)
+	for ttl := cfg.FirstTTL; ttl <= cfg.MaxTTL; ttl++ {
+		req := g.NewRequest(host, nil)
+		req.Config.TTL, req.Config.Count, req.Config.FlowID = ttl, cfg.Probes, cfg.FlowID
+		h := &hopState{id: req.ID, Hop: Hop{TTL: ttl}, runningStats: *newRunningStats()}
+		reqs, hops, byID[req.ID] = append(reqs, req), append(hops, h), h
+	}
+	go func() {
+		for _, req := range reqs {
+			ping <- req
+		}
+		close(ping)
+	}()
+
+	//Index of the first hop that ends the path
+	end := len(hops)
+	for r := range pong {
+		h := byID[r.Request.ID]
+		if h == nil || r.Err == ErrCancelled {
+			continue
+		}
+		h.add(r)
+		if i := h.TTL - cfg.FirstTTL; i < end && h.ends() {
+			//Stops probing the hops after the end of the path
+			for _, next := range hops[i+1 : end] {
+				g.Cancel(next.id)
+			}
+			end = i
+		}
+	}
+
+	path := Path{Host: host}
+	for i, h := range hops {
+		if i > end {
+			break
+		}
+		path.Hops = append(path.Hops, h.snapshot(host, true))
+		path.Reached = path.Reached || h.Reached
+	}
+	return path, ctx.Err()
+}
@@ -0,0 +1,112 @@
+package goping
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+//pathPinger simulates a path of routers. Probes with TTL up to len(routers) expire at routers[TTL-1].
+//The others reach the destination, which answers with err
+type pathPinger struct {
+	routers []net.IP
+	dst     net.IP
+	err     error
+	lost    map[int]bool   //TTLs whose probes are never answered
+	alt     map[int]net.IP //Address that answers every other probe of a TTL, like a load balanced hop
+	probes  map[int]int    //Number of probes received by TTL
+}
+
+func (m *pathPinger) Start(pid int) (ping chan<- SeqRequest, pong <-chan RawResponse, donepong <-chan struct{}, err error) {
+	in, out, done := make(chan SeqRequest), make(chan RawResponse), make(chan struct{})
+	m.probes = make(map[int]int)
+	go func() {
+		for r := range in {
+			ttl := r.Req.Config.TTL
+			m.probes[ttl]++
+			switch {
+			case m.lost[ttl]:
+			case m.alt[ttl] != nil && m.probes[ttl]%2 == 0:
+				out <- RawResponse{Seq: r.Seq, Peer: m.alt[ttl], RTT: float64(ttl), Err: ErrTimeExceeded}
+			case ttl <= len(m.routers):
+				out <- RawResponse{Seq: r.Seq, Peer: m.routers[ttl-1], RTT: float64(ttl), Err: ErrTimeExceeded}
+			case m.err == nil:
+				out <- RawResponse{Seq: r.Seq, Peer: m.dst, RTT: float64(ttl)}
+			default:
+				//The destination is unreachable. The last router answers without an RTT
+				out <- RawResponse{Seq: r.Seq, Peer: m.routers[len(m.routers)-1], RTT: math.NaN(), Err: m.err}
+			}
+		}
+		close(done)
+	}()
+	return in, out, done, nil
+}
+
+func TestTrace(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	routers := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}
+	p := &pathPinger{routers: routers, dst: net.IPv4(10, 0, 0, 4), lost: map[int]bool{2: true}}
+	path, err := Trace(context.Background(), New(cfg, p, nil, nil), "dst", TraceConfig{MaxTTL: 10, Probes: 2})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if !path.Reached || len(path.Hops) != 4 {
+		t.Fatalf("Expected the destination reached at hop 4. Got: Reached=%v Hops=%v", path.Reached, len(path.Hops))
+	}
+	for i, h := range path.Hops {
+		if h.TTL != i+1 {
+			t.Errorf("No match TTL. Expected: [%v], Got: [%v]", i+1, h.TTL)
+		}
+		if h.Stats.Sent != 2 {
+			t.Errorf("No match Sent of hop %v. Expected: [%v], Got: [%v]", h.TTL, 2, h.Stats.Sent)
+		}
+		if h.TTL == 2 {
+			if len(h.Peers) != 0 || h.Stats.Loss != 100 || h.Stats.Errors[ErrTimeout.Error()] != 2 {
+				t.Errorf("Expected hop 2 lost. Got: Peers=%v Loss=%v Errors=%v", h.Peers, h.Stats.Loss, h.Stats.Errors)
+			}
+			continue
+		}
+		expected := p.dst
+		if h.TTL <= len(routers) {
+			expected = routers[h.TTL-1]
+		}
+		if len(h.Peers) != 1 || !h.Peers[0].Equal(expected) {
+			t.Errorf("No match Peers of hop %v. Expected: [%v], Got: %v", h.TTL, expected, h.Peers)
+		}
+		if h.Stats.Received != 2 || h.Stats.Avg != float64(h.TTL) {
+			t.Errorf("No match stats of hop %v. Got: Received=%v Avg=%v", h.TTL, h.Stats.Received, h.Stats.Avg)
+		}
+		if h.Reached != (h.TTL == 4) || h.Err != nil {
+			t.Errorf("No match end of hop %v. Got: Reached=%v Err=%v", h.TTL, h.Reached, h.Err)
+		}
+	}
+}
+
+func TestTraceUnreachable(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	routers := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}
+	p := &pathPinger{routers: routers, err: ErrDstUnreachable}
+	path, err := Trace(context.Background(), New(cfg, p, nil, nil), "dst", TraceConfig{FirstTTL: 2, MaxTTL: 5, Probes: 1})
+	if err != nil {
+		t.Fatalf("Error not expected: %v", err)
+	}
+	if path.Reached || len(path.Hops) != 2 {
+		t.Fatalf("Expected the path to end at hop 3. Got: Reached=%v Hops=%v", path.Reached, len(path.Hops))
+	}
+	last := path.Hops[1]
+	if last.TTL != 3 || last.Err != ErrDstUnreachable || !last.Peers[0].Equal(routers[1]) {
+		t.Errorf("No match last hop. Got: TTL=%v Err=%v Peers=%v", last.TTL, last.Err, last.Peers)
+	}
+	if last.Stats.Received != 1 || !math.IsNaN(last.Stats.Avg) {
+		t.Errorf("Expected an answer without RTT. Got: Received=%v Avg=%v", last.Stats.Received, last.Stats.Avg)
+	}
+}
+
+func TestTraceConfig(t *testing.T) {
+	cfg := Config{Interval: time.Millisecond, Timeout: 20 * time.Millisecond}
+	if _, err := Trace(context.Background(), New(cfg, &pathPinger{}, nil, nil), "dst", TraceConfig{FirstTTL: 5, MaxTTL: 4}); err == nil {
+		t.Errorf("Error expected when FirstTTL is greater than MaxTTL")
+	}
+}